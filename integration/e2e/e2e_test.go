@@ -131,7 +131,7 @@ func RunQueryInstalledChaincodesExists(n *nwo.Network, orderer *nwo.Orderer, pee
 	})
 	Expect(err).NotTo(HaveOccurred())
 	Eventually(sess, n.EventuallyTimeout).Should(gexec.Exit(0))
-	Expect(sess).To(gbytes.Say("balancewasm\n"))
+	Expect(sess).To(gbytes.Say(`"name":"balancewasm"`))
 
 }
 
@@ -5,7 +5,7 @@
 # SPDX-License-Identifier: Apache-2.0
 
 declare -a vendoredModules=(
-"./wasmcc/wasmcc*.go"
+"./wasmcc/*.go"
 "./tools/file-encoder/*.go"
 "./integration/e2e/*.go"
 )
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Index name for the owners of installed wasm chaincodes
+var chaincodeOwnerIndex = "chaincodeOwner"
+
+// chaincodeOwner is the identity allowed to manage a chaincode besides the admins, the
+// creator of the chaincode unless ownership was transferred.
+type chaincodeOwner struct {
+	MSPID string `json:"mspId"`
+	// Certificate is PEM encoded
+	Certificate string `json:"certificate"`
+}
+
+// adminPrincipal grants admin rights to identities of an MSP, or only to those with a role,
+// an organizational unit of their certificate like admin with Fabric node OUs.
+type adminPrincipal struct {
+	MSPID string `json:"mspId"`
+	Role  string `json:"role,omitempty"`
+}
+
+// identity is the creator of a transaction.
+type identity struct {
+	mspID       string
+	certificate []byte
+	roles       []string
+}
+
+// creatorIdentity returns the identity which created the transaction. Fabric always sends a
+// creator, transactions without one, like those of the shim mock stub, get an anonymous
+// identity which only matches another anonymous one. Identities which are no X.509
+// certificate, like Idemix ones, only have an MSP ID.
+func creatorIdentity(stub shim.ChaincodeStubInterface) (*identity, error) {
+	creator, err := stub.GetCreator()
+	if err != nil || len(creator) == 0 {
+		return &identity{}, err
+	}
+
+	serialized := &msp.SerializedIdentity{}
+	if err := proto.Unmarshal(creator, serialized); err != nil {
+		return nil, err
+	}
+	id := &identity{mspID: serialized.Mspid}
+	block, _ := pem.Decode(serialized.IdBytes)
+	if block == nil {
+		return id, nil
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return id, nil
+	}
+	id.certificate = serialized.IdBytes
+	id.roles = certificate.Subject.OrganizationalUnit
+	return id, nil
+}
+
+// owns compares certificates by their DER encoding, the PEM encoding of a certificate may
+// differ in headers and line breaks. Identities of an MSP without certificate cannot prove
+// ownership and own no chaincode.
+func (id *identity) owns(owner *chaincodeOwner) bool {
+	if owner == nil || owner.MSPID != id.mspID || (id.certificate == nil && id.mspID != "") {
+		return false
+	}
+	return bytes.Equal(certificateDER([]byte(owner.Certificate)), certificateDER(id.certificate))
+}
+
+// certificateDER returns the DER encoding of a PEM encoded certificate, nil if there is none.
+func certificateDER(certificate []byte) []byte {
+	block, _ := pem.Decode(certificate)
+	if block == nil {
+		return nil
+	}
+	return block.Bytes
+}
+
+func (id *identity) isAdmin(admins []adminPrincipal) bool {
+	for _, admin := range admins {
+		if admin.MSPID != id.mspID {
+			continue
+		}
+		if admin.Role == "" {
+			return true
+		}
+		for _, role := range id.roles {
+			if role == admin.Role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loadAdmins returns the admin principals of the channel, none until admins are set.
+func loadAdmins(stub shim.ChaincodeStubInterface) ([]adminPrincipal, error) {
+	var admins []adminPrincipal
+	err := readConfigValue(stub, "admins", &admins)
+	return admins, err
+}
+
+func chaincodeOwnerKey(stub shim.ChaincodeStubInterface, chaincodeName string) (string, error) {
+	return stub.CreateCompositeKey(chaincodeOwnerIndex, []string{chaincodeName})
+}
+
+// loadOwner returns the owner of a chaincode, nil for chaincodes created before owners were
+// recorded.
+func loadOwner(stub shim.ChaincodeStubInterface, chaincodeName string) (*chaincodeOwner, error) {
+	key, err := chaincodeOwnerKey(stub, chaincodeName)
+	if err != nil {
+		return nil, err
+	}
+	ownerBytes, err := stub.GetState(key)
+	if err != nil || ownerBytes == nil {
+		return nil, err
+	}
+	owner := &chaincodeOwner{}
+	err = json.Unmarshal(ownerBytes, owner)
+	return owner, err
+}
+
+func storeOwner(stub shim.ChaincodeStubInterface, chaincodeName string, owner *chaincodeOwner) error {
+	ownerBytes, err := json.Marshal(owner)
+	if err != nil {
+		return err
+	}
+	key, err := chaincodeOwnerKey(stub, chaincodeName)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, ownerBytes)
+}
+
+// checkAdmin returns an error response unless the creator of the transaction is an admin.
+// Channels without admins deny every function checked, the first admins are not set by
+// setAdmins, which would let the first caller take over, but with the configuration wasmcc is
+// instantiated or upgraded with.
+func checkAdmin(stub shim.ChaincodeStubInterface) *pb.Response {
+	admins, err := loadAdmins(stub)
+	var id *identity
+	if err == nil {
+		id, err = creatorIdentity(stub)
+	}
+	if err != nil {
+		response := shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+		return &response
+	}
+	if len(admins) == 0 {
+		response := shim.Error(fmt.Sprintf(AccessDenied, "the first admins are set by instantiating or upgrading wasmcc with a configuration"))
+		return &response
+	}
+	if !id.isAdmin(admins) {
+		response := shim.Error(fmt.Sprintf(AccessDenied, "admin rights required"))
+		return &response
+	}
+	return nil
+}
+
+// checkOwnerOrAdmin returns an error response unless the creator of the transaction owns the
+// chaincode or is an admin. Chaincodes without owner can be managed by admins only, or by
+// everyone if the channel has no admins.
+func checkOwnerOrAdmin(stub shim.ChaincodeStubInterface, chaincodeName string) *pb.Response {
+	owner, err := loadOwner(stub, chaincodeName)
+	var id *identity
+	if err == nil {
+		id, err = creatorIdentity(stub)
+	}
+	var admins []adminPrincipal
+	if err == nil {
+		admins, err = loadAdmins(stub)
+	}
+	if err != nil {
+		response := shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+		return &response
+	}
+	if id.owns(owner) || (owner == nil && len(admins) == 0) || id.isAdmin(admins) {
+		return nil
+	}
+	if owner == nil {
+		response := shim.Error(fmt.Sprintf(AccessDenied, "admin rights required"))
+		return &response
+	}
+	response := shim.Error(fmt.Sprintf(AccessDenied, "chaincode "+chaincodeName+" is owned by another identity"))
+	return &response
+}
+
+// setAdmins replaces the admin principals of the channel. Receives the principals as json,
+// a list of objects with an MSP ID and an optional role. Only admins may change them, the
+// first admins are set with the configuration of wasmcc.
+func (t *WASMChaincode) setAdmins(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting admins as json")
+	}
+	if errResponse := checkAdmin(stub); errResponse != nil {
+		return *errResponse
+	}
+
+	config, err := loadChannelConfig(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	var admins []adminPrincipal
+	if err := json.Unmarshal([]byte(args[0]), &admins); err != nil {
+		return shim.Error(fmt.Sprintf(InvalidConfig, err.Error()))
+	}
+	config.Admins = admins
+	if err := config.validate(); err != nil {
+		return shim.Error(fmt.Sprintf(InvalidConfig, err.Error()))
+	}
+	if err := storeChannelConfig(stub, config); err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+
+	adminsBytes, err := json.Marshal(admins)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+
+	logger.Infof("Admins set to %s", adminsBytes)
+	return shim.Success(adminsBytes)
+}
+
+// admins returns the admin principals of the channel as json.
+func (t *WASMChaincode) admins(stub shim.ChaincodeStubInterface) pb.Response {
+	admins, err := loadAdmins(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	if admins == nil {
+		admins = []adminPrincipal{}
+	}
+	adminsBytes, err := json.Marshal(admins)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	return shim.Success(adminsBytes)
+}
+
+// owner returns the owner of a chaincode as json. Receives chaincode name.
+func (t *WASMChaincode) owner(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode name")
+	}
+	if _, errResponse := loadChaincodeCode(stub, chaincodeRef{name: args[0]}); errResponse != nil {
+		return *errResponse
+	}
+	owner, err := loadOwner(stub, args[0])
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	if owner == nil {
+		return shim.Error("{\"Error\":\"No owner of Chaincode " + args[0] + "\"}")
+	}
+	ownerBytes, err := json.Marshal(owner)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	return shim.Success(ownerBytes)
+}
+
+// transferOwnership makes another identity the owner of a chaincode. Receives chaincode
+// name, the MSP ID and the PEM encoded certificate of the new owner.
+func (t *WASMChaincode) transferOwnership(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode name, MSP ID and certificate of the new owner")
+	}
+	chaincodeName := args[0]
+	if _, errResponse := loadChaincodeCode(stub, chaincodeRef{name: chaincodeName}); errResponse != nil {
+		return *errResponse
+	}
+	if errResponse := checkOwnerOrAdmin(stub, chaincodeName); errResponse != nil {
+		return *errResponse
+	}
+
+	owner := &chaincodeOwner{MSPID: args[1], Certificate: args[2]}
+	if owner.MSPID == "" {
+		return shim.Error(fmt.Sprintf(InvalidConfig, "owner without MSP ID"))
+	}
+	block, _ := pem.Decode([]byte(owner.Certificate))
+	if block == nil {
+		return shim.Error(fmt.Sprintf(InvalidConfig, "owner certificate is not PEM encoded"))
+	}
+	if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+		return shim.Error(fmt.Sprintf(InvalidConfig, err.Error()))
+	}
+	if err := storeOwner(stub, chaincodeName, owner); err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+
+	logger.Infof("Ownership of %s transferred to a member of %s", chaincodeName, owner.MSPID)
+	return shim.Success(nil)
+}
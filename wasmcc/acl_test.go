@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// identityStub is a mock stub with a transaction creator, the mock stub of the shim has none.
+type identityStub struct {
+	*shim.MockStub
+	creator []byte
+	args    [][]byte
+}
+
+func (s *identityStub) GetCreator() ([]byte, error) {
+	return s.creator, nil
+}
+
+func (s *identityStub) GetArgs() [][]byte {
+	return s.args
+}
+
+// testIdentity returns a serialized identity with a self signed certificate, and the PEM
+// encoded certificate.
+func testIdentity(mspID, role string) ([]byte, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: role + "@" + mspID, OrganizationalUnit: []string{role}},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	creator, err := proto.Marshal(&msp.SerializedIdentity{Mspid: mspID, IdBytes: certificate})
+	if err != nil {
+		panic(err)
+	}
+	return creator, string(certificate)
+}
+
+// adminConfig is the configuration of test channels which makes testAdmin their admin.
+const adminConfig = `{"admins":[{"mspId":"AdminMSP"}]}`
+
+// testAdmin is the creator of the transactions sent by invokeAsAdmin.
+var testAdmin, _ = testIdentity("AdminMSP", "admin")
+
+// newAdminStub returns a stub of a channel instantiated with adminConfig.
+func newAdminStub(name string) *shim.MockStub {
+	stub := shim.NewMockStub(name, new(WASMChaincode))
+	stub.MockInit("000", [][]byte{[]byte("init"), []byte(adminConfig)})
+	return stub
+}
+
+// invokeAsAdmin invokes wasmcc as testAdmin, the channel settings require an admin.
+func invokeAsAdmin(stub *shim.MockStub, args ...[]byte) pb.Response {
+	stub.MockTransactionStart("admin")
+	defer stub.MockTransactionEnd("admin")
+	return new(WASMChaincode).Invoke(&identityStub{MockStub: stub, creator: testAdmin, args: args})
+}
+
+var _ = Describe("Tests for chaincode owners and admins", func() {
+
+	status200 := int32(200)
+	status500 := int32(500)
+
+	module := buildTestModule(nil, []testFunc{
+		{name: "init", params: []byte{i64}, results: []byte{i64}, body: returnI64(0)},
+	}, nil)
+
+	stub := shim.NewMockStub("aclStub", new(WASMChaincode))
+	stub.MockInit("000", nil)
+
+	alice, _ := testIdentity("Org1MSP", "client")
+	bob, bobCertificate := testIdentity("Org2MSP", "client")
+	admin, _ := testIdentity("Org1MSP", "admin")
+
+	invokeAs := func(creator []byte, args ...[]byte) pb.Response {
+		stub.MockTransactionStart("acl")
+		defer stub.MockTransactionEnd("acl")
+		return new(WASMChaincode).Invoke(&identityStub{MockStub: stub, creator: creator, args: args})
+	}
+
+	It("should record the creator as owner", func() {
+		result := invokeAs(alice, []byte("create"), []byte("owned"), module)
+		Expect(result.Status).Should(Equal(status200), result.Message)
+
+		result = invokeAs(bob, []byte("owner"), []byte("owned"))
+		Expect(result.Status).Should(Equal(status200))
+		Expect(string(result.Payload)).Should(ContainSubstring(`"mspId":"Org1MSP"`))
+	})
+	It("should let only the owner manage chaincodes without admins", func() {
+		result := invokeAs(bob, []byte("pause"), []byte("owned"))
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(Equal(fmt.Sprintf(AccessDenied, "chaincode owned is owned by another identity")))
+
+		result = invokeAs(alice, []byte("pause"), []byte("owned"))
+		Expect(result.Status).Should(Equal(status200), result.Message)
+		result = invokeAs(alice, []byte("resume"), []byte("owned"))
+		Expect(result.Status).Should(Equal(status200))
+	})
+	It("should deny channel settings and setting the first admins without admins", func() {
+		result := invokeAs(bob, []byte("setAdmins"), []byte(`[{"mspId":"Org2MSP"}]`))
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(ContainSubstring("the first admins are set by instantiating or upgrading wasmcc"))
+		result = invokeAs(bob, []byte("updateConfig"), []byte(`{"admins":[{"mspId":"Org2MSP"}]}`))
+		Expect(result.Status).Should(Equal(status500))
+		for _, args := range [][][]byte{
+			{[]byte("setEngine"), []byte("life")},
+			{[]byte("setHostGasSchedule"), []byte(`{"budget":1000}`)},
+			{[]byte("setRuntimeLimits"), []byte(`{"gasLimit":1000}`)},
+			{[]byte("setApprovalPolicy"), []byte(`{"orgs":["Org2MSP"]}`)},
+			{[]byte("collectModules")},
+			{[]byte("purgeState"), []byte("deleted")},
+		} {
+			result = invokeAs(bob, args...)
+			Expect(result.Status).Should(Equal(status500), string(args[0]))
+			Expect(result.Message).Should(ContainSubstring("the first admins are set by instantiating or upgrading wasmcc"))
+		}
+
+		result = invokeAs(bob, []byte("admins"))
+		Expect(string(result.Payload)).Should(Equal(`[]`))
+	})
+	It("should let only admins change admins once set", func() {
+		result := stub.MockInit("upgrade", [][]byte{[]byte("init"), []byte(`{"admins":[{"mspId":"Org2MSP"}]}`)})
+		Expect(result.Status).Should(Equal(status200), result.Message)
+		result = invokeAs(bob, []byte("setAdmins"), []byte(`[{"mspId":"Org1MSP","role":"admin"}]`))
+		Expect(result.Status).Should(Equal(status200), result.Message)
+
+		result = invokeAs(bob, []byte("setAdmins"), []byte(`[{"mspId":"Org2MSP"}]`))
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(Equal(fmt.Sprintf(AccessDenied, "admin rights required")))
+		result = invokeAs(alice, []byte("setEngine"), []byte("life"))
+		Expect(result.Status).Should(Equal(status500))
+		result = invokeAs(alice, []byte("collectModules"))
+		Expect(result.Status).Should(Equal(status500))
+
+		result = invokeAs(bob, []byte("admins"))
+		Expect(string(result.Payload)).Should(Equal(`[{"mspId":"Org1MSP","role":"admin"}]`))
+	})
+	It("should let admins manage chaincodes of others", func() {
+		result := invokeAs(admin, []byte("setTag"), []byte("owned"), []byte("stable"), []byte("1"))
+		Expect(result.Status).Should(Equal(status200), result.Message)
+		result = invokeAs(bob, []byte("removeTag"), []byte("owned"), []byte("stable"))
+		Expect(result.Status).Should(Equal(status500))
+
+		result = invokeAs(alice, []byte("setRuntimeLimits"), []byte("owned"), []byte(`{"gasLimit":1000}`))
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(Equal(fmt.Sprintf(AccessDenied, "admin rights required")))
+		result = invokeAs(admin, []byte("setRuntimeLimits"), []byte("owned"), []byte(`{"gasLimit":1000}`))
+		Expect(result.Status).Should(Equal(status200), result.Message)
+	})
+	It("should transfer ownership", func() {
+		result := invokeAs(bob, []byte("transferOwnership"), []byte("owned"), []byte("Org2MSP"), []byte(bobCertificate))
+		Expect(result.Status).Should(Equal(status500))
+		result = invokeAs(alice, []byte("transferOwnership"), []byte("owned"), []byte("Org2MSP"), []byte("not a certificate"))
+		Expect(result.Status).Should(Equal(status500))
+
+		// the owner is the same certificate in another PEM encoding
+		reencoded := strings.Replace(bobCertificate, "\n", "\r\n", -1)
+		result = invokeAs(alice, []byte("transferOwnership"), []byte("owned"), []byte("Org2MSP"), []byte(reencoded))
+		Expect(result.Status).Should(Equal(status200), result.Message)
+		result = invokeAs(alice, []byte("upgrade"), []byte("owned"), module)
+		Expect(result.Status).Should(Equal(status500))
+		result = invokeAs(bob, []byte("upgrade"), []byte("owned"), module)
+		Expect(result.Status).Should(Equal(status200), result.Message)
+	})
+	It("should accept creators without certificate but not as owners", func() {
+		idemix, err := proto.Marshal(&msp.SerializedIdentity{Mspid: "Org1MSP", IdBytes: []byte("idemix identity")})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		result := invokeAs(idemix, []byte("create"), []byte("unowned"), module)
+		Expect(result.Status).Should(Equal(status200), result.Message)
+		result = invokeAs(idemix, []byte("pause"), []byte("unowned"))
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(Equal(fmt.Sprintf(AccessDenied, "chaincode unowned is owned by another identity")))
+		result = invokeAs(admin, []byte("pause"), []byte("unowned"))
+		Expect(result.Status).Should(Equal(status200), result.Message)
+	})
+	It("should delete the owner with the chaincode", func() {
+		result := invokeAs(bob, []byte("delete"), []byte("owned"))
+		Expect(result.Status).Should(Equal(status200), result.Message)
+		key, _ := chaincodeOwnerKey(stub, "owned")
+		Expect(stub.State).ShouldNot(HaveKey(key))
+	})
+})
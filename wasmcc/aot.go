@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-interpreter/wagon/disasm"
+	"github.com/go-interpreter/wagon/wasm"
+
+	"github.com/perlin-network/life/compiler"
+	"github.com/perlin-network/life/exec"
+	"github.com/perlin-network/life/utils"
+)
+
+// nativeCodegenVersion is part of the name of native artifacts. It must be increased whenever
+// the generated code changes, so artifacts compiled by an older wasmcc are not reused.
+const nativeCodegenVersion = 2
+
+// Directory native artifacts are cached in and C compiler used to build them, in builds
+// with ahead-of-time compilation.
+var (
+	nativeCacheDir = envOrDefault("WASMCC_AOT_CACHE_DIR", filepath.Join(os.TempDir(), "wasmcc-aot"))
+	nativeCompiler = envOrDefault("WASMCC_AOT_CC", "cc")
+)
+
+func envOrDefault(name, value string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return value
+}
+
+// nativeArtifactName names the native artifact of a compiled module in the on-disk cache.
+func nativeArtifactName(key moduleCacheKey) string {
+	return fmt.Sprintf("%s-t%d-v%d.so", hex.EncodeToString(key.codeHash[:]), key.tableSize, nativeCodegenVersion)
+}
+
+// nativeRuntimePrelude is added to the generated code. It extends life's VirtualMachine with
+// the state wasmcc needs to meter native code exactly like the interpreter. The layout must
+// match struct wasmcc_native in aot_native.h.
+const nativeRuntimePrelude = `
+struct wasmcc_limits {
+	struct VirtualMachine vm;
+	uint64_t gas;
+	uint64_t gas_limit;
+	uint64_t depth;
+	uint64_t max_depth;
+	uint64_t max_memory_pages;
+};
+#define WASMCC_LIMITS(vm) ((struct wasmcc_limits *) (vm))
+static void __attribute__((always_inline)) wasmcc_add_gas(struct VirtualMachine *vm, uint64_t delta) {
+	uint64_t gas = WASMCC_LIMITS(vm)->gas + delta;
+	if(gas < WASMCC_LIMITS(vm)->gas) vm->throw_s(vm, "gas overflow");
+	if(WASMCC_LIMITS(vm)->gas_limit != 0 && gas > WASMCC_LIMITS(vm)->gas_limit) vm->throw_s(vm, "gas limit exceeded");
+	WASMCC_LIMITS(vm)->gas = gas;
+}
+static uint64_t __attribute__((always_inline)) wasmcc_grow_memory(struct VirtualMachine *vm, uint32_t pages) {
+	uint64_t current = vm->mem_size / 65536;
+	if(WASMCC_LIMITS(vm)->max_memory_pages != 0 && current + pages > WASMCC_LIMITS(vm)->max_memory_pages) return (uint64_t) -1;
+	vm->grow_memory(vm, (uint64_t) pages * 65536);
+	return current;
+}
+`
+
+// generateNativeCode generates C code for a compiled module. It follows life's
+// Module.NCompile, but charges gas and enforces the memory and call stack depth limits
+// exactly like the interpreter does, so native and interpreted execution of a module give
+// the same results and use the same amount of gas.
+func generateNativeCode(module *exec.Module) (code string, retErr error) {
+	defer utils.CatchPanic(&retErr)
+
+	m := module.Module
+	out := &strings.Builder{}
+	out.WriteString(module.GenerateNEnv(exec.NCompileConfig{}))
+	out.WriteString(nativeRuntimePrelude)
+
+	fmt.Fprintf(out, "void wasmcc_set_globals(const uint64_t *values) {\n")
+	for i := range module.Globals {
+		fmt.Fprintf(out, "globals[%d] = values[%d];\n", i, i)
+	}
+	fmt.Fprintf(out, "}\n")
+
+	importTypeIDs := make([]int, 0)
+	if m.Base.Import != nil {
+		for _, e := range m.Base.Import.Entries {
+			if e.Type.Kind() != wasm.ExternalFunction {
+				continue
+			}
+			typeID := int(e.Type.(wasm.FuncImport).Type)
+			writeImportStub(out, len(importTypeIDs), len(m.Base.Types.Entries[typeID].ParamTypes))
+			importTypeIDs = append(importTypeIDs, typeID)
+		}
+	}
+	numFuncImports := len(importTypeIDs)
+
+	for i, f := range m.Base.FunctionIndexSpace {
+		instrs, err := disasm.Disassemble(f.Body.Code)
+		if err != nil {
+			return "", err
+		}
+
+		c := compiler.NewSSAFunctionCompiler(m.Base, &disasm.Disassembly{Code: instrs, MaxDepth: 512})
+		c.CallIndexOffset = numFuncImports
+		c.Compile(importTypeIDs)
+		if m.DisableFloatingPoint {
+			c.FilterFloatingPoint()
+		}
+		if module.GasPolicy != nil {
+			c.InsertGasCounters(module.GasPolicy)
+		}
+
+		numLocals := 0
+		for _, v := range f.Body.Locals {
+			numLocals += int(v.Count)
+		}
+
+		functionID := numFuncImports + i
+		numParams := len(f.Sig.ParamTypes)
+		body := c.NGen(uint64(functionID), uint64(numParams), uint64(numLocals), uint64(len(module.Globals)))
+		out.WriteString(meterFunction(body, c.Code, functionID))
+		writeDepthCheckedFunction(out, functionID, numParams)
+	}
+
+	return out.String(), nil
+}
+
+// writeImportStub writes the function through which native code calls a host function.
+func writeImportStub(out *strings.Builder, importID, numParams int) {
+	fmt.Fprintf(out, "uint64_t %s%d(struct VirtualMachine *vm", compiler.NGEN_FUNCTION_PREFIX, importID)
+	for j := 0; j < numParams; j++ {
+		fmt.Fprintf(out, ",uint64_t %s%d", compiler.NGEN_LOCAL_PREFIX, j)
+	}
+	out.WriteString(") {\nuint64_t params[] = {")
+	for j := 0; j < numParams; j++ {
+		fmt.Fprintf(out, "%s%d,", compiler.NGEN_LOCAL_PREFIX, j)
+	}
+	fmt.Fprintf(out, "0};\nreturn %sinvoke_import(vm, %d, %d, params);\n}\n", compiler.NGEN_ENV_API_PREFIX, importID, numParams)
+}
+
+// meterFunction renames a function generated by life to its body and fills in the gas
+// counters and memory growth, which life's code generator does not implement.
+func meterFunction(body string, code []compiler.Instr, functionID int) string {
+	body = strings.Replace(body,
+		fmt.Sprintf("uint64_t %s%d(", compiler.NGEN_FUNCTION_PREFIX, functionID),
+		fmt.Sprintf("static uint64_t wasmcc_body_%d(", functionID), 1)
+
+	for i, ins := range code {
+		label := fmt.Sprintf("\n%s%d: ", compiler.NGEN_INS_LABEL_PREFIX, i)
+		switch ins.Op {
+		case "add_gas":
+			body = strings.Replace(body, label+"\n",
+				fmt.Sprintf("%swasmcc_add_gas(vm, %dull);\n", label, uint64(ins.Immediates[0])), 1)
+		case "memory.grow":
+			start := strings.Index(body, label)
+			end := strings.Index(body[start+1:], "\n") + start + 1
+			body = body[:start] + fmt.Sprintf("%s%s%d.vu64 = wasmcc_grow_memory(vm, %s%d.vu32);",
+				label, compiler.NGEN_VALUE_PREFIX, ins.Target, compiler.NGEN_VALUE_PREFIX, ins.Values[0]) + body[end:]
+		}
+	}
+	return body
+}
+
+// writeDepthCheckedFunction writes the function called by other wasm code, which counts the
+// depth of the wasm call stack like the call frames of the interpreter.
+func writeDepthCheckedFunction(out *strings.Builder, functionID, numParams int) {
+	var params, args strings.Builder
+	for j := 0; j < numParams; j++ {
+		fmt.Fprintf(&params, ",uint64_t %s%d", compiler.NGEN_LOCAL_PREFIX, j)
+		fmt.Fprintf(&args, ",%s%d", compiler.NGEN_LOCAL_PREFIX, j)
+	}
+
+	fmt.Fprintf(out, "uint64_t %s%d(struct VirtualMachine *vm%s) {\n", compiler.NGEN_FUNCTION_PREFIX, functionID, params.String())
+	out.WriteString("uint64_t ret;\n")
+	out.WriteString("if(WASMCC_LIMITS(vm)->max_depth != 0 && WASMCC_LIMITS(vm)->depth >= WASMCC_LIMITS(vm)->max_depth) vm->throw_s(vm, \"max call stack depth exceeded\");\n")
+	out.WriteString("WASMCC_LIMITS(vm)->depth++;\n")
+	fmt.Fprintf(out, "ret = wasmcc_body_%d(vm%s);\n", functionID, args.String())
+	out.WriteString("WASMCC_LIMITS(vm)->depth--;\n")
+	out.WriteString("return ret;\n}\n")
+}
@@ -0,0 +1,19 @@
+//go:build !wasmcc_aot
+// +build !wasmcc_aot
+
+package main
+
+import (
+	"github.com/perlin-network/life/exec"
+)
+
+// nativeCodeEnabled reports whether wasmcc was built with ahead-of-time compilation.
+const nativeCodeEnabled = false
+
+// attachNativeCode is a no-op without ahead-of-time compilation, modules are interpreted.
+func attachNativeCode(vm *exec.VirtualMachine, key moduleCacheKey, module *exec.Module) (release func()) {
+	return func() {}
+}
+
+// dropNativeCode is a no-op without ahead-of-time compilation.
+func dropNativeCode(key moduleCacheKey) {}
@@ -0,0 +1,93 @@
+//go:build wasmcc_aot
+// +build wasmcc_aot
+
+#include <stdlib.h>
+#include <string.h>
+
+#include "aot_native.h"
+#include "_cgo_export.h"
+
+// Traps unwind to wasmcc_native_invoke. Only C frames of the generated code are skipped,
+// host functions always return to C before a trap is raised.
+static void wasmcc_throw(struct VirtualMachine *vm, const char *s) {
+	struct wasmcc_native *n = (struct wasmcc_native *) vm;
+	n->error = s;
+	longjmp(n->trap, 1);
+}
+
+static uint64_t wasmcc_dispatch_import(struct VirtualMachine *vm, uint64_t import_id, uint64_t num_params, uint64_t *params) {
+	struct wasmcc_native *n = (struct wasmcc_native *) vm;
+	uint64_t result = 0;
+	if(wasmccInvokeHost(n->handle, import_id, num_params, params, &result) != 0) {
+		// the error is kept by the host
+		wasmcc_throw(vm, 0);
+	}
+	return result;
+}
+
+static ExternalFunction wasmcc_resolve_import(struct VirtualMachine *vm, const char *module_name, const char *field_name) {
+	return wasmcc_dispatch_import;
+}
+
+static void wasmcc_grow_memory(struct VirtualMachine *vm, uint64_t inc_size) {
+	uint8_t *mem;
+	if(vm->mem_size + inc_size < vm->mem_size) {
+		wasmcc_throw(vm, "memory size overflow");
+	}
+	mem = realloc(vm->mem, vm->mem_size + inc_size);
+	if(mem == 0 && vm->mem_size + inc_size != 0) {
+		wasmcc_throw(vm, "out of memory");
+	}
+	memset(mem + vm->mem_size, 0, inc_size);
+	vm->mem = mem;
+	vm->mem_size += inc_size;
+}
+
+struct wasmcc_native *wasmcc_native_new(uint64_t handle, const uint8_t *mem, uint64_t mem_size) {
+	struct wasmcc_native *n = calloc(1, sizeof(struct wasmcc_native));
+	if(n == 0) {
+		return 0;
+	}
+	n->vm.throw_s = wasmcc_throw;
+	n->vm.resolve_import = wasmcc_resolve_import;
+	n->vm.grow_memory = wasmcc_grow_memory;
+	n->vm.mem_size = mem_size;
+	n->vm.mem = malloc(mem_size > 0 ? mem_size : 1);
+	if(n->vm.mem == 0) {
+		free(n);
+		return 0;
+	}
+	if(mem_size > 0) {
+		memcpy(n->vm.mem, mem, mem_size);
+	}
+	n->handle = handle;
+	return n;
+}
+
+void wasmcc_native_free(struct wasmcc_native *n) {
+	free(n->vm.mem);
+	free(n);
+}
+
+// wasmcc_native_invoke calls a function of the generated code. It returns 1 if the function
+// trapped, with the reason in n->error unless a host function failed.
+int wasmcc_native_invoke(struct wasmcc_native *n, void *function, uint64_t num_params, uint64_t p0, uint64_t p1, uint64_t *result) {
+	if(setjmp(n->trap) != 0) {
+		return 1;
+	}
+	switch(num_params) {
+	case 0:
+		*result = ((uint64_t (*)(struct VirtualMachine *)) function)(&n->vm);
+		break;
+	case 1:
+		*result = ((uint64_t (*)(struct VirtualMachine *, uint64_t)) function)(&n->vm, p0);
+		break;
+	default:
+		*result = ((uint64_t (*)(struct VirtualMachine *, uint64_t, uint64_t)) function)(&n->vm, p0, p1);
+	}
+	return 0;
+}
+
+void wasmcc_native_set_globals(void *set, const uint64_t *values) {
+	((void (*)(const uint64_t *)) set)(values);
+}
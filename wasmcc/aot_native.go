@@ -0,0 +1,323 @@
+//go:build wasmcc_aot
+// +build wasmcc_aot
+
+package main
+
+/*
+#cgo LDFLAGS: -ldl
+
+#include <dlfcn.h>
+#include <stdlib.h>
+#include "aot_native.h"
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	life "github.com/perlin-network/life/exec"
+)
+
+// nativeCodeEnabled reports whether wasmcc was built with ahead-of-time compilation.
+const nativeCodeEnabled = true
+
+// nativeArtifact is the natively compiled code of a module, loaded into the peer process.
+type nativeArtifact struct {
+	// invocations are serialized because wasm globals live in the shared library
+	mu      sync.Mutex
+	ready   bool
+	failed  bool
+	evicted bool
+	handle  unsafe.Pointer
+	symbols map[string]unsafe.Pointer
+}
+
+var (
+	nativeArtifactsLock sync.Mutex
+	nativeArtifacts     = make(map[moduleCacheKey]*nativeArtifact)
+
+	nativeInstancesLock sync.Mutex
+	nativeInstances     = make(map[uint64]*nativeInstance)
+	nextNativeInstance  uint64
+)
+
+// attachNativeCode makes the vm run natively compiled code of the module. Modules are
+// compiled in the background the first time they are run, and interpreted until their native
+// code is ready or if it cannot be compiled. The returned function releases the native state
+// and must be called after the vm ran.
+func attachNativeCode(vm *life.VirtualMachine, key moduleCacheKey, module *life.Module) (release func()) {
+	nativeArtifactsLock.Lock()
+	artifact, ok := nativeArtifacts[key]
+	if !ok {
+		artifact = &nativeArtifact{symbols: make(map[string]unsafe.Pointer)}
+		nativeArtifacts[key] = artifact
+		go artifact.compile(key, module)
+	}
+	ready := artifact.ready && !artifact.failed
+	nativeArtifactsLock.Unlock()
+
+	if !ready {
+		return func() {}
+	}
+
+	artifact.mu.Lock()
+	if artifact.handle == nil {
+		// the module was evicted after its native code was found ready
+		artifact.mu.Unlock()
+		return func() {}
+	}
+	var globals *C.uint64_t
+	if len(vm.Globals) > 0 {
+		globals = (*C.uint64_t)(unsafe.Pointer(&vm.Globals[0]))
+	}
+	C.wasmcc_native_set_globals(artifact.symbol("wasmcc_set_globals"), globals)
+
+	instance := &nativeInstance{artifact: artifact}
+	nativeInstancesLock.Lock()
+	nextNativeInstance++
+	instance.handle = nextNativeInstance
+	nativeInstances[instance.handle] = instance
+	nativeInstancesLock.Unlock()
+
+	vm.SetAOTService(instance)
+	return func() {
+		nativeInstancesLock.Lock()
+		delete(nativeInstances, instance.handle)
+		nativeInstancesLock.Unlock()
+
+		if instance.native != nil {
+			C.wasmcc_native_free(instance.native)
+		}
+		vm.Memory = nil
+		vm.AOTService = nil
+		artifact.mu.Unlock()
+	}
+}
+
+// compile loads the native artifact of a module from the on-disk cache, compiling it first
+// if it is not cached yet.
+func (a *nativeArtifact) compile(key moduleCacheKey, module *life.Module) {
+	handle, err := loadNativeArtifact(key, module)
+
+	nativeArtifactsLock.Lock()
+	defer nativeArtifactsLock.Unlock()
+	if err != nil {
+		logger.Warningf("Native compilation of wasm module %x failed, interpreting it: %s", key.codeHash, err)
+		a.failed = true
+		return
+	}
+	if a.evicted {
+		C.dlclose(handle)
+		return
+	}
+	logger.Infof("Native code of wasm module %x ready", key.codeHash)
+	a.handle = handle
+	a.ready = true
+}
+
+// dropNativeCode unloads the native code of a module evicted from the compiled module cache.
+// The shared library stays in the on-disk cache and is closed once no invocation runs it.
+func dropNativeCode(key moduleCacheKey) {
+	nativeArtifactsLock.Lock()
+	artifact, ok := nativeArtifacts[key]
+	if ok {
+		delete(nativeArtifacts, key)
+		artifact.evicted = true
+	}
+	nativeArtifactsLock.Unlock()
+
+	if ok {
+		// eviction must not wait for running invocations
+		go artifact.close()
+	}
+}
+
+// close closes the shared library of an evicted artifact after the running invocation.
+func (a *nativeArtifact) close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	nativeArtifactsLock.Lock()
+	handle := a.handle
+	a.handle = nil
+	a.ready = false
+	nativeArtifactsLock.Unlock()
+	if handle != nil {
+		C.dlclose(handle)
+	}
+}
+
+func loadNativeArtifact(key moduleCacheKey, module *life.Module) (unsafe.Pointer, error) {
+	path := filepath.Join(nativeCacheDir, nativeArtifactName(key))
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := buildNativeArtifact(path, module); err != nil {
+			return nil, err
+		}
+	}
+
+	pathC := C.CString(path)
+	defer C.free(unsafe.Pointer(pathC))
+	handle := C.dlopen(pathC, C.RTLD_NOW|C.RTLD_LOCAL)
+	if handle == nil {
+		return nil, errors.New(C.GoString(C.dlerror()))
+	}
+	return handle, nil
+}
+
+// buildNativeArtifact compiles the generated C code of a module into a shared library.
+func buildNativeArtifact(path string, module *life.Module) error {
+	code, err := generateNativeCode(module)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	buildDir, err := ioutil.TempDir(filepath.Dir(path), "build-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(buildDir)
+
+	source := filepath.Join(buildDir, "module.c")
+	if err := ioutil.WriteFile(source, []byte(code), 0644); err != nil {
+		return err
+	}
+	library := filepath.Join(buildDir, "module.so")
+	out, err := exec.Command(nativeCompiler, "-fPIC", "-O2", "-shared", "-o", library, source, "-lm").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %s", err, out)
+	}
+
+	// rename is atomic, concurrent peers never load a partially written artifact
+	return os.Rename(library, path)
+}
+
+func (a *nativeArtifact) symbol(name string) unsafe.Pointer {
+	if sym, ok := a.symbols[name]; ok {
+		return sym
+	}
+
+	nameC := C.CString(name)
+	defer C.free(unsafe.Pointer(nameC))
+	sym := C.dlsym(a.handle, nameC)
+	if sym == nil {
+		panic(fmt.Errorf("native function %s not found", name))
+	}
+	a.symbols[name] = sym
+	return sym
+}
+
+// nativeInstance runs one invocation of native code. It implements life's AOTService.
+type nativeInstance struct {
+	artifact *nativeArtifact
+	handle   uint64
+	native   *C.struct_wasmcc_native
+	vm       *life.VirtualMachine
+	hostErr  error
+}
+
+func (n *nativeInstance) Initialize(vm *life.VirtualMachine) {
+	var mem *C.uint8_t
+	if len(vm.Memory) > 0 {
+		mem = (*C.uint8_t)(unsafe.Pointer(&vm.Memory[0]))
+	}
+	n.native = C.wasmcc_native_new(C.uint64_t(n.handle), mem, C.uint64_t(len(vm.Memory)))
+	if n.native == nil {
+		panic(errors.New("out of memory"))
+	}
+	n.native.gas = C.uint64_t(vm.Gas)
+	n.native.gas_limit = C.uint64_t(vm.Config.GasLimit)
+	n.native.max_depth = C.uint64_t(vm.Config.MaxCallStackDepth)
+	n.native.max_memory_pages = C.uint64_t(vm.Config.MaxMemoryPages)
+	n.vm = vm
+	n.syncMemory()
+}
+
+// syncMemory lets host functions access the linear memory of the native code.
+func (n *nativeInstance) syncMemory() {
+	size := int(n.native.vm.mem_size)
+	if size == 0 {
+		n.vm.Memory = nil
+		return
+	}
+	n.vm.Memory = (*[1 << 32]byte)(unsafe.Pointer(n.native.vm.mem))[:size:size]
+}
+
+func (n *nativeInstance) invoke(vm *life.VirtualMachine, name string, numParams int, p0, p1 uint64) uint64 {
+	var result C.uint64_t
+	trapped := C.wasmcc_native_invoke(n.native, n.artifact.symbol(name), C.uint64_t(numParams), C.uint64_t(p0), C.uint64_t(p1), &result)
+	vm.Gas = uint64(n.native.gas)
+	n.syncMemory()
+
+	if trapped != 0 {
+		if n.native.error == nil && n.hostErr != nil {
+			panic(n.hostErr)
+		}
+		panic(errors.New(C.GoString(n.native.error)))
+	}
+	return uint64(result)
+}
+
+func (n *nativeInstance) UnsafeInvokeFunction_0(vm *life.VirtualMachine, name string) uint64 {
+	return n.invoke(vm, name, 0, 0, 0)
+}
+
+func (n *nativeInstance) UnsafeInvokeFunction_1(vm *life.VirtualMachine, name string, p0 uint64) uint64 {
+	return n.invoke(vm, name, 1, p0, 0)
+}
+
+func (n *nativeInstance) UnsafeInvokeFunction_2(vm *life.VirtualMachine, name string, p0, p1 uint64) uint64 {
+	return n.invoke(vm, name, 2, p0, p1)
+}
+
+// wasmccInvokeHost calls a host function on behalf of native code. Host functions see the
+// gas used and the memory of the native code, and their panics are returned as failure so
+// that they never unwind through C frames.
+//
+//export wasmccInvokeHost
+func wasmccInvokeHost(handle C.uint64_t, importID C.uint64_t, numParams C.uint64_t, params *C.uint64_t, result *C.uint64_t) (failed C.int) {
+	nativeInstancesLock.Lock()
+	n := nativeInstances[uint64(handle)]
+	nativeInstancesLock.Unlock()
+
+	vm := n.vm
+	defer func() {
+		n.native.gas = C.uint64_t(vm.Gas)
+		if err := recover(); err != nil {
+			if e, ok := err.(error); ok {
+				n.hostErr = e
+			} else {
+				n.hostErr = fmt.Errorf("%v", err)
+			}
+			failed = 1
+		}
+	}()
+
+	vm.Gas = uint64(n.native.gas)
+	n.syncMemory()
+
+	// function imports are shared by all vms of a module, resolve them per call
+	imp := vm.FunctionImports[importID]
+	f := vm.ImportResolver.ResolveFunc(imp.ModuleName, imp.FieldName)
+
+	locals := make([]int64, int(numParams))
+	if numParams > 0 {
+		for i, p := range (*[1 << 16]C.uint64_t)(unsafe.Pointer(params))[:numParams:numParams] {
+			locals[i] = int64(p)
+		}
+	}
+	vm.CurrentFrame = 0
+	vm.GetCurrentFrame().Locals = locals
+
+	*result = C.uint64_t(f(vm))
+	return 0
+}
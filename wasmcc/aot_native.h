@@ -0,0 +1,39 @@
+#ifndef WASMCC_AOT_NATIVE_H
+#define WASMCC_AOT_NATIVE_H
+
+#include <setjmp.h>
+#include <stdint.h>
+
+// Must match the VirtualMachine of life's generated code (compiler.NGEN_HEADER).
+struct VirtualMachine;
+typedef uint64_t (*ExternalFunction)(struct VirtualMachine *vm, uint64_t import_id, uint64_t num_params, uint64_t *params);
+struct VirtualMachine {
+	void (*throw_s)(struct VirtualMachine *vm, const char *s);
+	ExternalFunction (*resolve_import)(struct VirtualMachine *vm, const char *module_name, const char *field_name);
+	uint64_t mem_size;
+	uint8_t *mem;
+	void (*grow_memory)(struct VirtualMachine *vm, uint64_t inc_size);
+	void *userdata;
+};
+
+// State of one native invocation. The fields up to max_memory_pages are shared with the
+// generated code and must match struct wasmcc_limits in aot.go.
+struct wasmcc_native {
+	struct VirtualMachine vm;
+	uint64_t gas;
+	uint64_t gas_limit;
+	uint64_t depth;
+	uint64_t max_depth;
+	uint64_t max_memory_pages;
+
+	uint64_t handle;
+	const char *error;
+	jmp_buf trap;
+};
+
+struct wasmcc_native *wasmcc_native_new(uint64_t handle, const uint8_t *mem, uint64_t mem_size);
+void wasmcc_native_free(struct wasmcc_native *n);
+int wasmcc_native_invoke(struct wasmcc_native *n, void *function, uint64_t num_params, uint64_t p0, uint64_t p1, uint64_t *result);
+void wasmcc_native_set_globals(void *set, const uint64_t *values);
+
+#endif
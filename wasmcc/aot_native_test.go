@@ -0,0 +1,148 @@
+//go:build wasmcc_aot
+// +build wasmcc_aot
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tests for natively compiled wasm chaincode", func() {
+
+	status200 := int32(200)
+
+	const opMemoryGrow, opI64ExtendI32S = 0x40, 0xac
+
+	nativeModule := buildTestModule(nil, []testFunc{
+		{name: "init", params: []byte{i64}, results: []byte{i64}, body: returnI64(0)},
+		{name: "grow", params: []byte{i64}, results: []byte{i64}, body: []byte{opI32Const, 4, opMemoryGrow, 0, opI64ExtendI32S}},
+		{name: "recurse", params: []byte{i64}, results: []byte{i64}, body: []byte{opLocalGet, 0, opCall, 2}},
+		{name: "spin", params: []byte{i64}, results: []byte{i64}, body: []byte{opLoop, 0x40, opBr, 0, opEnd, opUnreachable}},
+	}, nil)
+
+	// waitForNativeCode waits until the background compilation of the modules of this test
+	// finished.
+	waitForNativeCode := func() {
+		codeHashes := map[[sha256.Size]byte]bool{
+			sha256.Sum256(ReadAssetTransferWASM()): true,
+			sha256.Sum256(nativeModule):            true,
+			sha256.Sum256(reactorModule):           true,
+		}
+		Eventually(func() bool {
+			nativeArtifactsLock.Lock()
+			defer nativeArtifactsLock.Unlock()
+			for key, artifact := range nativeArtifacts {
+				if !codeHashes[key.codeHash] {
+					continue
+				}
+				if !artifact.ready && !artifact.failed {
+					return false
+				}
+				Expect(artifact.failed).Should(BeFalse())
+			}
+			return true
+		}, 2*time.Minute, 100*time.Millisecond).Should(BeTrue())
+	}
+
+	var cacheDir string
+
+	BeforeEach(func() {
+		var err error
+		cacheDir, err = ioutil.TempDir("", "wasmcc-aot-test-")
+		Expect(err).ShouldNot(HaveOccurred())
+		nativeCacheDir = cacheDir
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(cacheDir)
+	})
+
+	It("should give the same results and gas as the interpreter", func() {
+		invocations := [][][]byte{
+			{[]byte("execute"), []byte("balancewasm"), []byte("query"), []byte("account1")},
+			{[]byte("execute"), []byte("balancewasm"), []byte("invoke"), []byte("account1"), []byte("account2"), []byte("10")},
+			{[]byte("execute"), []byte("balancewasm"), []byte("query"), []byte("account1")},
+			{[]byte("execute"), []byte("balancewasm"), []byte("query"), []byte("account3")},
+			{[]byte("execute"), []byte("nativewasm"), []byte("grow")},
+			{[]byte("execute"), []byte("nativewasm"), []byte("recurse")},
+			{[]byte("execute"), []byte("nativewasm"), []byte("spin")},
+			{[]byte("execute"), []byte("reactorwasm"), []byte("count")},
+		}
+
+		// run runs all invocations on a new ledger and returns their results
+		run := func() []string {
+			stub := newAdminStub("nativeStub")
+
+			result := stub.MockInvoke("000",
+				[][]byte{[]byte("create"), []byte("balancewasm"), ReadAssetTransferWASM(),
+					[]byte("account1"), []byte("100"), []byte("account2"), []byte("1000")})
+			Expect(result.Status).Should(Equal(status200))
+			result = stub.MockInvoke("000",
+				[][]byte{[]byte("create"), []byte("nativewasm"), nativeModule})
+			Expect(result.Status).Should(Equal(status200))
+			result = stub.MockInvoke("000",
+				[][]byte{[]byte("create"), []byte("reactorwasm"), reactorModule})
+			Expect(result.Status).Should(Equal(status200))
+			result = invokeAsAdmin(stub, []byte("setRuntimeLimits"), []byte("nativewasm"), []byte(`{"maxMemoryPages":2,"maxCallStackDepth":16,"gasLimit":5000}`))
+			Expect(result.Status).Should(Equal(status200))
+
+			var results []string
+			for _, args := range invocations {
+				result := stub.MockInvoke("000", args)
+				results = append(results, fmt.Sprintf("%d|%s|%s|%d", result.Status, result.Payload, result.Message, reportedGasUsed(stub)))
+			}
+			return results
+		}
+
+		interpreted := run()
+		Expect(interpreted[2]).Should(HavePrefix("200|90|"))
+		Expect(interpreted[6]).Should(HavePrefix("500|"))
+		Expect(interpreted[7]).Should(HavePrefix("200|101|"))
+
+		waitForNativeCode()
+		Expect(run()).Should(Equal(interpreted))
+	})
+	It("should close the native code of modules evicted from the module cache", func() {
+		stub := shim.NewMockStub("evictedNativeStub", new(WASMChaincode))
+		stub.MockInit("000", nil)
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("create"), []byte("nativewasm"), nativeModule})
+		Expect(result.Status).Should(Equal(status200))
+		waitForNativeCode()
+
+		var key moduleCacheKey
+		var artifact *nativeArtifact
+		nativeArtifactsLock.Lock()
+		for k, a := range nativeArtifacts {
+			if k.codeHash == sha256.Sum256(nativeModule) {
+				key, artifact = k, a
+			}
+		}
+		nativeArtifactsLock.Unlock()
+		Expect(artifact).ShouldNot(BeNil())
+
+		compiledModules.onEvict(key)
+		Eventually(func() bool {
+			nativeArtifactsLock.Lock()
+			defer nativeArtifactsLock.Unlock()
+			return artifact.handle == nil
+		}).Should(BeTrue())
+		nativeArtifactsLock.Lock()
+		Expect(nativeArtifacts).ShouldNot(HaveKey(key))
+		nativeArtifactsLock.Unlock()
+
+		result = stub.MockInvoke("000",
+			[][]byte{[]byte("execute"), []byte("nativewasm"), []byte("grow")})
+		Expect(result.Status).Should(Equal(status200))
+		Expect(string(result.Payload)).Should(Equal("1"))
+	})
+})
@@ -0,0 +1,30 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tests for native code generation", func() {
+
+	const opMemoryGrow, opI64ExtendI32S = 0x40, 0xac
+
+	module := buildTestModule(nil, []testFunc{
+		{name: "init", params: []byte{i64}, results: []byte{i64}, body: returnI64(0)},
+		{name: "grow", params: []byte{i64}, results: []byte{i64}, body: []byte{opI32Const, 4, opMemoryGrow, 0, opI64ExtendI32S}},
+	}, nil)
+
+	It("should charge gas and limit memory like the interpreter", func() {
+		config := defaultRuntimeLimits().vmConfig()
+		compiled, err := loadModule(newModuleCacheKey(module, config), module, config, &Resolver{})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		code, err := generateNativeCode(compiled)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(code).Should(ContainSubstring("wasmcc_add_gas(vm, "))
+		Expect(code).Should(ContainSubstring("= wasmcc_grow_memory(vm, "))
+		Expect(code).ShouldNot(ContainSubstring("vm->grow_memory(vm, v"))
+		Expect(code).Should(ContainSubstring("static uint64_t wasmcc_body_1("))
+		Expect(code).Should(ContainSubstring("max call stack depth exceeded"))
+	})
+})
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Index name for proposals of wasm chaincodes awaiting approval
+var chaincodeProposalIndex = "chaincodeProposal"
+
+// approvalPolicy names the organizations which approve wasm chaincode deployments and how
+// many of them must approve. While a policy is set, chaincodes are created and upgraded by
+// propose, approve and commit only.
+type approvalPolicy struct {
+	Orgs []string `json:"orgs"`
+	// Threshold is the number of approving organizations required, a majority if 0
+	Threshold int `json:"threshold,omitempty"`
+}
+
+func (p *approvalPolicy) validate() error {
+	seen := make(map[string]bool)
+	for _, org := range p.Orgs {
+		if org == "" || seen[org] {
+			return fmt.Errorf("empty or repeated organization %q", org)
+		}
+		seen[org] = true
+	}
+	if p.Threshold < 0 || p.Threshold > len(p.Orgs) {
+		return fmt.Errorf("threshold %d out of range, expecting 0 to %d", p.Threshold, len(p.Orgs))
+	}
+	return nil
+}
+
+func (p *approvalPolicy) required() int {
+	if p.Threshold == 0 {
+		return len(p.Orgs)/2 + 1
+	}
+	return p.Threshold
+}
+
+func (p *approvalPolicy) includes(mspID string) bool {
+	for _, org := range p.Orgs {
+		if org == mspID {
+			return true
+		}
+	}
+	return false
+}
+
+// approvals counts the approving organizations which are part of the policy.
+func (p *approvalPolicy) approvals(mspIDs []string) int {
+	count := 0
+	for _, mspID := range mspIDs {
+		if p.includes(mspID) {
+			count++
+		}
+	}
+	return count
+}
+
+// chaincodeProposal is code and parameters proposed for a chaincode, created by commit if
+// the chaincode is not installed and upgraded to otherwise.
+type chaincodeProposal struct {
+	CodeHash string `json:"codeHash"`
+	// Args are the parameters of init, or of migrate for upgrades
+	Args     [][]byte `json:"args"`
+	Proposer string   `json:"proposer"`
+	// ProposerCertificate is PEM encoded, the proposer owns chaincodes created by commit
+	ProposerCertificate string   `json:"proposerCertificate"`
+	Approvals           []string `json:"approvals"`
+}
+
+func approvalPolicyKey(stub shim.ChaincodeStubInterface) (string, error) {
+	return stub.CreateCompositeKey(wasmccConfigIndex, []string{"approvalPolicy"})
+}
+
+// loadApprovalPolicy returns the approval policy of the channel, nil if none is set.
+func loadApprovalPolicy(stub shim.ChaincodeStubInterface) (*approvalPolicy, error) {
+	key, err := approvalPolicyKey(stub)
+	if err != nil {
+		return nil, err
+	}
+	policyBytes, err := stub.GetState(key)
+	if err != nil || policyBytes == nil {
+		return nil, err
+	}
+	policy := &approvalPolicy{}
+	err = json.Unmarshal(policyBytes, policy)
+	return policy, err
+}
+
+// checkApprovalNotRequired returns an error response if chaincodes must be deployed by the
+// approval workflow. Rolling back and tagging versions are refused as well, as they change
+// the code run by chaincodes without approval.
+func checkApprovalNotRequired(stub shim.ChaincodeStubInterface) *pb.Response {
+	policy, err := loadApprovalPolicy(stub)
+	if err != nil {
+		response := shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+		return &response
+	}
+	if policy != nil {
+		response := shim.Error(fmt.Sprintf(AccessDenied, "the code of chaincodes is only changed by propose, approve and commit"))
+		return &response
+	}
+	return nil
+}
+
+// policyMember returns the identity of the creator of the transaction, if the approval
+// policy of the channel includes its organization.
+func policyMember(stub shim.ChaincodeStubInterface) (*approvalPolicy, *identity, *pb.Response) {
+	policy, err := loadApprovalPolicy(stub)
+	var id *identity
+	if err == nil {
+		id, err = creatorIdentity(stub)
+	}
+	if err != nil {
+		response := shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+		return nil, nil, &response
+	}
+	if policy == nil {
+		response := shim.Error(fmt.Sprintf(InvalidConfig, "no approval policy"))
+		return nil, nil, &response
+	}
+	if !policy.includes(id.mspID) {
+		response := shim.Error(fmt.Sprintf(AccessDenied, "organization "+id.mspID+" is not part of the approval policy"))
+		return nil, nil, &response
+	}
+	return policy, id, nil
+}
+
+func chaincodeProposalKey(stub shim.ChaincodeStubInterface, chaincodeName string) (string, error) {
+	return stub.CreateCompositeKey(chaincodeProposalIndex, []string{chaincodeName})
+}
+
+// loadProposal returns the proposal of a chaincode, nil if there is none.
+func loadProposal(stub shim.ChaincodeStubInterface, chaincodeName string) (*chaincodeProposal, error) {
+	key, err := chaincodeProposalKey(stub, chaincodeName)
+	if err != nil {
+		return nil, err
+	}
+	proposalBytes, err := stub.GetState(key)
+	if err != nil || proposalBytes == nil {
+		return nil, err
+	}
+	proposal := &chaincodeProposal{}
+	err = json.Unmarshal(proposalBytes, proposal)
+	return proposal, err
+}
+
+func storeProposal(stub shim.ChaincodeStubInterface, chaincodeName string, proposal *chaincodeProposal) ([]byte, error) {
+	proposalBytes, err := json.Marshal(proposal)
+	if err != nil {
+		return nil, err
+	}
+	key, err := chaincodeProposalKey(stub, chaincodeName)
+	if err != nil {
+		return nil, err
+	}
+	return proposalBytes, stub.PutState(key, proposalBytes)
+}
+
+// checkProposerOrAdmin returns an error response unless the creator of the transaction made
+// the pending proposal of a chaincode or is an admin, the only identities which may replace or
+// withdraw it and with it the approvals collected.
+func checkProposerOrAdmin(stub shim.ChaincodeStubInterface, chaincodeName string, proposal *chaincodeProposal) *pb.Response {
+	id, err := creatorIdentity(stub)
+	var admins []adminPrincipal
+	if err == nil {
+		admins, err = loadAdmins(stub)
+	}
+	if err != nil {
+		response := shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+		return &response
+	}
+	proposer := &chaincodeOwner{MSPID: proposal.Proposer, Certificate: proposal.ProposerCertificate}
+	if id.owns(proposer) || id.isAdmin(admins) {
+		return nil
+	}
+	response := shim.Error(fmt.Sprintf(AccessDenied, "the proposal for chaincode "+chaincodeName+" was made by another identity"))
+	return &response
+}
+
+// parseCodeHash validates a hex encoded sha256 hash of code.
+func parseCodeHash(hash string) (string, error) {
+	hash = strings.ToLower(hash)
+	if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+		return "", errors.New("invalid code hash " + hash + ", expecting hex encoded sha256")
+	}
+	return hash, nil
+}
+
+// setApprovalPolicy sets the approval policy of the channel. Receives the policy as json, a
+// policy without organizations lets chaincodes be created and upgraded directly again.
+func (t *WASMChaincode) setApprovalPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting approval policy as json")
+	}
+	if errResponse := checkAdmin(stub); errResponse != nil {
+		return *errResponse
+	}
+
+	policy := &approvalPolicy{}
+	if err := json.Unmarshal([]byte(args[0]), policy); err != nil {
+		return shim.Error(fmt.Sprintf(InvalidConfig, err.Error()))
+	}
+	if err := policy.validate(); err != nil {
+		return shim.Error(fmt.Sprintf(InvalidConfig, err.Error()))
+	}
+
+	key, err := approvalPolicyKey(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	if len(policy.Orgs) == 0 {
+		if err := stub.DelState(key); err != nil {
+			return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+		}
+		logger.Infof("Approval policy removed")
+		return shim.Success(nil)
+	}
+
+	policyBytes, err := json.Marshal(policy)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	if err := stub.PutState(key, policyBytes); err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+
+	logger.Infof("Approval policy set to %s", policyBytes)
+	return shim.Success(policyBytes)
+}
+
+// approvalPolicy returns the approval policy of the channel as json, null if none is set.
+func (t *WASMChaincode) approvalPolicy(stub shim.ChaincodeStubInterface) pb.Response {
+	policy, err := loadApprovalPolicy(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	policyBytes, err := json.Marshal(policy)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	return shim.Success(policyBytes)
+}
+
+// propose proposes code and parameters for a chaincode. The organization of the proposer
+// approves it. A pending proposal and its approvals are only replaced by its proposer or an
+// admin. Receives chaincode name, the hash of the code and the parameters of init, or of
+// migrate for upgrades.
+func (t *WASMChaincode) propose(stub shim.ChaincodeStubInterface, args [][]byte) pb.Response {
+	if len(args) < 2 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode name, code hash and parameters")
+	}
+	chaincodeName := string(args[0])
+	if err := checkChaincodeName(chaincodeName); err != nil {
+		return shim.Error(err.Error())
+	}
+	hash, err := parseCodeHash(string(args[1]))
+	if err != nil {
+		return shim.Error(fmt.Sprintf(InvalidConfig, err.Error()))
+	}
+	_, proposer, errResponse := policyMember(stub)
+	if errResponse != nil {
+		return *errResponse
+	}
+	mspID := proposer.mspID
+
+	pending, err := loadProposal(stub, chaincodeName)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	if pending != nil {
+		if errResponse := checkProposerOrAdmin(stub, chaincodeName, pending); errResponse != nil {
+			return *errResponse
+		}
+	}
+
+	proposal := &chaincodeProposal{
+		CodeHash:            hash,
+		Args:                args[2:],
+		Proposer:            mspID,
+		ProposerCertificate: string(proposer.certificate),
+		Approvals:           []string{mspID},
+	}
+	proposalBytes, err := storeProposal(stub, chaincodeName, proposal)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+
+	logger.Infof("%s proposed code %s for %s", mspID, hash, chaincodeName)
+	return shim.Success(proposalBytes)
+}
+
+// approve approves the proposal of a chaincode for the organization of the creator of the
+// transaction. Receives chaincode name and the code hash being approved, which must be the
+// proposed one.
+func (t *WASMChaincode) approve(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode name and code hash")
+	}
+	chaincodeName := args[0]
+	hash, err := parseCodeHash(args[1])
+	if err != nil {
+		return shim.Error(fmt.Sprintf(InvalidConfig, err.Error()))
+	}
+	_, approver, errResponse := policyMember(stub)
+	if errResponse != nil {
+		return *errResponse
+	}
+	mspID := approver.mspID
+
+	proposal, err := loadProposal(stub, chaincodeName)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	if proposal == nil {
+		return shim.Error("{\"Error\":\"No proposal for Chaincode " + chaincodeName + "\"}")
+	}
+	if proposal.CodeHash != hash {
+		return shim.Error(fmt.Sprintf(CodeHashMismatch, chaincodeName, proposal.CodeHash, hash))
+	}
+
+	approved := false
+	for _, approval := range proposal.Approvals {
+		approved = approved || approval == mspID
+	}
+	if !approved {
+		proposal.Approvals = append(proposal.Approvals, mspID)
+	}
+	proposalBytes, err := storeProposal(stub, chaincodeName, proposal)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+
+	logger.Infof("%s approved code %s for %s", mspID, hash, chaincodeName)
+	return shim.Success(proposalBytes)
+}
+
+// proposal returns the proposal of a chaincode as json. Receives chaincode name.
+func (t *WASMChaincode) proposal(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode name")
+	}
+	proposal, err := loadProposal(stub, args[0])
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	if proposal == nil {
+		return shim.Error("{\"Error\":\"No proposal for Chaincode " + args[0] + "\"}")
+	}
+	proposalBytes, err := json.Marshal(proposal)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	return shim.Success(proposalBytes)
+}
+
+// withdraw deletes the pending proposal of a chaincode with its approvals. Only the proposer
+// or an admin may withdraw it. Receives chaincode name.
+func (t *WASMChaincode) withdraw(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode name")
+	}
+	chaincodeName := args[0]
+	proposal, err := loadProposal(stub, chaincodeName)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	if proposal == nil {
+		return shim.Error("{\"Error\":\"No proposal for Chaincode " + chaincodeName + "\"}")
+	}
+	if errResponse := checkProposerOrAdmin(stub, chaincodeName, proposal); errResponse != nil {
+		return *errResponse
+	}
+
+	key, err := chaincodeProposalKey(stub, chaincodeName)
+	if err == nil {
+		err = stub.DelState(key)
+	}
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+
+	logger.Infof("Proposal of code %s for %s withdrawn", proposal.CodeHash, chaincodeName)
+	return shim.Success(nil)
+}
+
+// commit creates or upgrades a chaincode with the proposed code and parameters, once enough
+// organizations of the approval policy approved them. Only members of the policy may commit,
+// and the proposer, not the committer, owns created chaincodes. Receives chaincode name and
+// the code, in the same forms as create, which must have the proposed hash.
+func (t *WASMChaincode) commit(stub shim.ChaincodeStubInterface, args [][]byte) pb.Response {
+	logger.Infof("Commit function")
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode name and wasm chaincode")
+	}
+	chaincodeName := string(args[0])
+	policy, _, errResponse := policyMember(stub)
+	if errResponse != nil {
+		return *errResponse
+	}
+
+	proposal, err := loadProposal(stub, chaincodeName)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	if proposal == nil {
+		return shim.Error("{\"Error\":\"No proposal for Chaincode " + chaincodeName + "\"}")
+	}
+	code, err := decodeReceivedWASMChaincode(args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if actual := codeHash(code); actual != proposal.CodeHash {
+		return shim.Error(fmt.Sprintf(CodeHashMismatch, chaincodeName, actual, proposal.CodeHash))
+	}
+
+	if approvals := policy.approvals(proposal.Approvals); approvals < policy.required() {
+		return shim.Error(fmt.Sprintf(NotApproved, chaincodeName, approvals, policy.required()))
+	}
+
+	key, err := chaincodeProposalKey(stub, chaincodeName)
+	if err == nil {
+		err = stub.DelState(key)
+	}
+	var installed []byte
+	var ledgerChaincodeKey string
+	if err == nil {
+		ledgerChaincodeKey, err = stub.CreateCompositeKey(chaincodeStoreIndex, []string{chaincodeName})
+	}
+	if err == nil {
+		installed, err = stub.GetState(ledgerChaincodeKey)
+	}
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+
+	logger.Infof("Committing code %s for %s approved by %v", proposal.CodeHash, chaincodeName, proposal.Approvals)
+	deployArgs := append([][]byte{args[0], code}, proposal.Args...)
+	if installed == nil {
+		proposer := &identity{mspID: proposal.Proposer, certificate: []byte(proposal.ProposerCertificate)}
+		return t.createOwnedBy(stub, deployArgs, proposer)
+	}
+	return t.upgradeVersion(stub, deployArgs, true)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tests for the chaincode approval workflow", func() {
+
+	status200 := int32(200)
+	status500 := int32(500)
+
+	votedModule := func(version int64) []byte {
+		return buildTestModule(nil, []testFunc{
+			{name: "init", params: []byte{i64}, results: []byte{i64}, body: returnI64(0)},
+			{name: "version", params: []byte{i64}, results: []byte{i64}, body: returnI64(version)},
+		}, nil)
+	}
+	v1, v2 := votedModule(1), votedModule(2)
+
+	stub := shim.NewMockStub("approvalStub", new(WASMChaincode))
+	stub.MockInit("000", [][]byte{[]byte("init"), []byte(`{"admins":[{"mspId":"Org1MSP"}]}`)})
+
+	org1, _ := testIdentity("Org1MSP", "admin")
+	org2, _ := testIdentity("Org2MSP", "admin")
+	org3, _ := testIdentity("Org3MSP", "admin")
+	org4, _ := testIdentity("Org4MSP", "admin")
+
+	invokeAs := func(creator []byte, args ...[]byte) pb.Response {
+		stub.MockTransactionStart("approval")
+		defer stub.MockTransactionEnd("approval")
+		return new(WASMChaincode).Invoke(&identityStub{MockStub: stub, creator: creator, args: args})
+	}
+
+	It("should require commit once a policy is set", func() {
+		result := invokeAs(org1, []byte("setApprovalPolicy"), []byte(`{"orgs":["Org1MSP","Org2MSP"],"threshold":3}`))
+		Expect(result.Status).Should(Equal(status500))
+
+		result = invokeAs(org1, []byte("setApprovalPolicy"), []byte(`{"orgs":["Org1MSP","Org2MSP","Org3MSP"]}`))
+		Expect(result.Status).Should(Equal(status200), result.Message)
+
+		result = invokeAs(org1, []byte("create"), []byte("voted"), v1)
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(ContainSubstring(`"code":409`))
+	})
+	It("should only let organizations of the policy propose", func() {
+		result := invokeAs(org4, []byte("propose"), []byte("voted"), []byte(codeHash(v1)))
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(ContainSubstring("Org4MSP is not part of the approval policy"))
+
+		result = invokeAs(org1, []byte("propose"), []byte("voted"), []byte(codeHash(v1)), []byte("param"))
+		Expect(result.Status).Should(Equal(status200), result.Message)
+		Expect(string(result.Payload)).Should(ContainSubstring(`"approvals":["Org1MSP"]`))
+	})
+	It("should not commit before the policy is met", func() {
+		result := invokeAs(org4, []byte("commit"), []byte("voted"), v1)
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(ContainSubstring("Org4MSP is not part of the approval policy"))
+
+		result = invokeAs(org3, []byte("commit"), []byte("voted"), v2)
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(Equal(fmt.Sprintf(CodeHashMismatch, "voted", codeHash(v2), codeHash(v1))))
+
+		result = invokeAs(org3, []byte("commit"), []byte("voted"), v1)
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(Equal(fmt.Sprintf(NotApproved, "voted", 1, 2)))
+	})
+	It("should commit approved chaincodes", func() {
+		result := invokeAs(org2, []byte("approve"), []byte("voted"), []byte(codeHash(v2)))
+		Expect(result.Status).Should(Equal(status500))
+		result = invokeAs(org2, []byte("approve"), []byte("voted"), []byte(codeHash(v1)))
+		Expect(result.Status).Should(Equal(status200), result.Message)
+		Expect(string(result.Payload)).Should(ContainSubstring(`"approvals":["Org1MSP","Org2MSP"]`))
+
+		result = invokeAs(org3, []byte("commit"), []byte("voted"), v1)
+		Expect(result.Status).Should(Equal(status200), result.Message)
+		result = invokeAs(org3, []byte("proposal"), []byte("voted"))
+		Expect(result.Status).Should(Equal(status500))
+		result = invokeAs(org3, []byte("execute"), []byte("voted"), []byte("version"))
+		Expect(string(result.Payload)).Should(Equal("1"))
+
+		// the proposer owns the chaincode, not the committer
+		result = invokeAs(org3, []byte("owner"), []byte("voted"))
+		Expect(string(result.Payload)).Should(ContainSubstring(`"mspId":"Org1MSP"`))
+		result = invokeAs(org1, []byte("pause"), []byte("voted"))
+		Expect(result.Status).Should(Equal(status200), result.Message)
+		result = invokeAs(org1, []byte("resume"), []byte("voted"))
+		Expect(result.Status).Should(Equal(status200), result.Message)
+	})
+	It("should commit approved upgrades", func() {
+		result := invokeAs(org2, []byte("propose"), []byte("voted"), []byte(codeHash(v2)))
+		Expect(result.Status).Should(Equal(status200), result.Message)
+		result = invokeAs(org3, []byte("approve"), []byte("voted"), []byte(codeHash(v2)))
+		Expect(result.Status).Should(Equal(status200), result.Message)
+
+		result = invokeAs(org1, []byte("commit"), []byte("voted"), v2)
+		Expect(result.Status).Should(Equal(status200), result.Message)
+		Expect(string(result.Payload)).Should(ContainSubstring(`"version":2`))
+		result = invokeAs(org1, []byte("execute"), []byte("voted"), []byte("version"))
+		Expect(string(result.Payload)).Should(Equal("2"))
+	})
+	It("should not roll back or tag versions while a policy is set", func() {
+		result := invokeAs(org1, []byte("rollback"), []byte("voted"), []byte("1"))
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(ContainSubstring(`"code":409`))
+		result = invokeAs(org1, []byte("setTag"), []byte("voted"), []byte("stable"), []byte("1"))
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(ContainSubstring(`"code":409`))
+
+		result = invokeAs(org1, []byte("execute"), []byte("voted"), []byte("version"))
+		Expect(string(result.Payload)).Should(Equal("2"))
+	})
+	It("should only let the proposer or an admin replace or withdraw a proposal", func() {
+		result := invokeAs(org2, []byte("propose"), []byte("voted"), []byte(codeHash(v1)))
+		Expect(result.Status).Should(Equal(status200), result.Message)
+		result = invokeAs(org3, []byte("approve"), []byte("voted"), []byte(codeHash(v1)))
+		Expect(result.Status).Should(Equal(status200), result.Message)
+
+		result = invokeAs(org3, []byte("propose"), []byte("voted"), []byte(codeHash(v2)))
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(ContainSubstring(`"code":409`))
+		result = invokeAs(org3, []byte("withdraw"), []byte("voted"))
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(ContainSubstring(`"code":409`))
+		result = invokeAs(org3, []byte("proposal"), []byte("voted"))
+		Expect(string(result.Payload)).Should(ContainSubstring(`"approvals":["Org2MSP","Org3MSP"]`))
+
+		// the proposer replaces its own proposal, the admin withdraws it
+		result = invokeAs(org2, []byte("propose"), []byte("voted"), []byte(codeHash(v2)))
+		Expect(result.Status).Should(Equal(status200), result.Message)
+		Expect(string(result.Payload)).Should(ContainSubstring(`"approvals":["Org2MSP"]`))
+		result = invokeAs(org1, []byte("withdraw"), []byte("voted"))
+		Expect(result.Status).Should(Equal(status200), result.Message)
+		result = invokeAs(org1, []byte("proposal"), []byte("voted"))
+		Expect(result.Status).Should(Equal(status500))
+	})
+	It("should create chaincodes directly again without policy", func() {
+		result := invokeAs(org1, []byte("setApprovalPolicy"), []byte(`{"orgs":[]}`))
+		Expect(result.Status).Should(Equal(status200), result.Message)
+		result = invokeAs(org1, []byte("approvalPolicy"))
+		Expect(string(result.Payload)).Should(Equal("null"))
+
+		result = invokeAs(org1, []byte("create"), []byte("direct"), v1)
+		Expect(result.Status).Should(Equal(status200), result.Message)
+	})
+})
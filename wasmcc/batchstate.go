@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Batch host functions exchange lists of keys and values as packed buffers, every item is
+// prefixed by its length as little endian uint32. A length of missingValueLength marks a
+// key which has no value, in results of reads, or which is deleted, in batches of writes.
+const missingValueLength = 0xffffffff
+
+var errMalformedBatch = errors.New("malformed batch buffer")
+
+// batchWrite is a write or delete of a batch.
+type batchWrite struct {
+	key     []byte
+	value   []byte
+	deleted bool
+}
+
+// readBatchItem reads a length prefixed item at the start of buf. Missing is true if the
+// item is marked missing.
+func readBatchItem(buf []byte) (item []byte, rest []byte, missing bool, err error) {
+	if len(buf) < 4 {
+		return nil, nil, false, errMalformedBatch
+	}
+	length := binary.LittleEndian.Uint32(buf)
+	buf = buf[4:]
+	if length == missingValueLength {
+		return nil, buf, true, nil
+	}
+	if uint64(length) > uint64(len(buf)) {
+		return nil, nil, false, errMalformedBatch
+	}
+	return buf[:length], buf[length:], false, nil
+}
+
+// unpackKeys reads a list of keys.
+func unpackKeys(buf []byte) ([][]byte, error) {
+	var keys [][]byte
+	for len(buf) > 0 {
+		key, rest, missing, err := readBatchItem(buf)
+		if err != nil {
+			return nil, err
+		}
+		if missing {
+			return nil, errMalformedBatch
+		}
+		keys = append(keys, key)
+		buf = rest
+	}
+	return keys, nil
+}
+
+// unpackWrites reads a list of key value pairs. Values are copied, keys and values may be
+// kept after the wasm memory is released.
+func unpackWrites(buf []byte) ([]batchWrite, error) {
+	var writes []batchWrite
+	for len(buf) > 0 {
+		key, rest, missing, err := readBatchItem(buf)
+		if err != nil {
+			return nil, err
+		}
+		if missing {
+			return nil, errMalformedBatch
+		}
+		value, rest, deleted, err := readBatchItem(rest)
+		if err != nil {
+			return nil, err
+		}
+
+		write := batchWrite{key: append([]byte(nil), key...), deleted: deleted}
+		if !deleted {
+			write.value = append([]byte{}, value...)
+		}
+		writes = append(writes, write)
+		buf = rest
+	}
+	return writes, nil
+}
+
+// packValues writes a list of values, nil values are marked missing.
+func packValues(values [][]byte) []byte {
+	size := 0
+	for _, value := range values {
+		size += 4 + len(value)
+	}
+
+	buf := make([]byte, 0, size)
+	var length [4]byte
+	for _, value := range values {
+		if value == nil {
+			binary.LittleEndian.PutUint32(length[:], missingValueLength)
+		} else {
+			binary.LittleEndian.PutUint32(length[:], uint32(len(value)))
+		}
+		buf = append(buf, length[:]...)
+		buf = append(buf, value...)
+	}
+	return buf
+}
+
+// getStates reads the values of a packed list of keys and returns them packed. Fabric has no
+// batch reads, keys are read one by one through the state overlay, which reads each key from
+// the ledger once. The bytes read are charged by the caller.
+func (r *Resolver) getStates(env hostEnv, function string, keysBuf []byte) ([]byte, error) {
+	keys, err := unpackKeys(keysBuf)
+	if err != nil {
+		return nil, err
+	}
+	keyBytes := 0
+	for _, key := range keys {
+		keyBytes += len(key)
+	}
+	r.chargeHostCall(env, function, keyBytes, 0, 0)
+	r.chargeHostKeys(env, "__get_state", len(keys))
+
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		values[i], err = r.state.get(fmt.Sprintf("%s_%s", r.chaincodeName, key))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return packValues(values), nil
+}
+
+// putStates buffers a packed batch of writes and deletes. The batch is applied as a whole,
+// a malformed batch does not change state.
+func (r *Resolver) putStates(env hostEnv, function string, batch []byte) error {
+	writes, err := unpackWrites(batch)
+	if err != nil {
+		return err
+	}
+
+	keyBytes, writeBytes, deletes := 0, 0, 0
+	for _, write := range writes {
+		keyBytes += len(write.key)
+		writeBytes += len(write.value)
+		if write.deleted {
+			deletes++
+		}
+	}
+	r.chargeHostCall(env, function, keyBytes, 0, writeBytes)
+	r.chargeHostKeys(env, "__put_state", len(writes)-deletes)
+	r.chargeHostKeys(env, "__delete_state", deletes)
+
+	for _, write := range writes {
+		key := fmt.Sprintf("%s_%s", r.chaincodeName, write.key)
+		if write.deleted {
+			r.state.del(key)
+		} else {
+			r.state.put(key, write.value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/binary"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// packItems packs items for batch host functions, nil items are marked missing.
+func packItems(items ...[]byte) []byte {
+	var buf []byte
+	for _, item := range items {
+		length := make([]byte, 4)
+		if item == nil {
+			binary.LittleEndian.PutUint32(length, missingValueLength)
+		} else {
+			binary.LittleEndian.PutUint32(length, uint32(len(item)))
+		}
+		buf = append(append(buf, length...), item...)
+	}
+	return buf
+}
+
+var _ = Describe("Tests for batch state host functions", func() {
+
+	status200 := int32(200)
+
+	const opI32WrapI64 = 0xa7
+
+	Describe("Packed buffers", func() {
+		It("should unpack writes and deletes", func() {
+			writes, err := unpackWrites(packItems([]byte("a"), []byte("1"), []byte("b"), nil))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(writes).Should(Equal([]batchWrite{
+				{key: []byte("a"), value: []byte("1")},
+				{key: []byte("b"), deleted: true},
+			}))
+		})
+		It("should reject truncated buffers", func() {
+			_, err := unpackKeys(packItems([]byte("abc"))[:5])
+			Expect(err).Should(Equal(errMalformedBatch))
+			_, err = unpackWrites(packItems([]byte("a")))
+			Expect(err).Should(Equal(errMalformedBatch))
+		})
+		It("should mark missing values", func() {
+			Expect(packValues([][]byte{[]byte("v"), nil, {}})).Should(Equal(packItems([]byte("v"), nil, []byte{})))
+		})
+	})
+
+	Describe("Batch wasm chaincode", func() {
+		imports := []testImport{
+			{field: "__put_states", params: []byte{i32, i32}, results: []byte{i64}},
+			{field: "__get_states", params: []byte{i32, i32, i32}, results: []byte{i64}},
+			{field: "__return_result", params: []byte{i32, i32}, results: []byte{i64}},
+		}
+		const putStates, getStates, returnResult = 0, 1, 2
+
+		created := packItems([]byte("a"), []byte("1"), []byte("b"), []byte("22"), []byte("c"), []byte("333"))
+		updated := packItems([]byte("b"), []byte("4"), []byte("c"), nil)
+		keys := packItems([]byte("a"), []byte("b"), []byte("c"), []byte("d"))
+		data := concat(created, updated, keys)
+
+		i32Const := func(v int) []byte {
+			return concat([]byte{opI32Const}, sleb(int64(v)))
+		}
+		putBatch := func(offset int, batch []byte) []byte {
+			return concat(i32Const(offset), i32Const(len(batch)), []byte{opCall, putStates})
+		}
+		// getAll returns the packed values of all keys as result
+		getAll := concat(i32Const(1024),
+			i32Const(len(created)+len(updated)), i32Const(len(keys)), i32Const(1024), []byte{opCall, getStates, opI32WrapI64},
+			[]byte{opCall, returnResult, opDrop}, returnI64(0))
+
+		batchModule := buildTestModule(imports, []testFunc{
+			{name: "init", params: []byte{i64}, results: []byte{i64}, body: putBatch(0, created)},
+			{name: "get", params: []byte{i64}, results: []byte{i64}, body: getAll},
+			{name: "updateget", params: []byte{i64}, results: []byte{i64}, body: concat(putBatch(len(created), updated), []byte{opDrop}, getAll)},
+			{name: "malformed", params: []byte{i64}, results: []byte{i64}, body: putBatch(0, created[:3])},
+		}, data)
+
+		stub := shim.NewMockStub("batchStub", new(WASMChaincode))
+		stub.MockInit("000", nil)
+
+		It("should write a batch in init", func() {
+			result := stub.MockInvoke("000",
+				[][]byte{[]byte("create"), []byte("batchwasm"), batchModule})
+			Expect(result.Status).Should(Equal(status200))
+
+			result = stub.MockInvoke("000",
+				[][]byte{[]byte("execute"), []byte("batchwasm"), []byte("get")})
+			Expect(result.Status).Should(Equal(status200))
+			Expect(result.Payload).Should(Equal(packItems([]byte("1"), []byte("22"), []byte("333"), nil)))
+		})
+		It("should read writes and deletes of the same batch invocation", func() {
+			result := stub.MockInvoke("000",
+				[][]byte{[]byte("execute"), []byte("batchwasm"), []byte("updateget")})
+			Expect(result.Status).Should(Equal(status200))
+			Expect(result.Payload).Should(Equal(packItems([]byte("1"), []byte("4"), nil, nil)))
+			Expect(string(stub.State["batchwasm_b"])).Should(Equal("4"))
+			Expect(stub.State).ShouldNot(HaveKey("batchwasm_c"))
+		})
+		It("should reject malformed batches", func() {
+			result := stub.MockInvoke("000",
+				[][]byte{[]byte("execute"), []byte("batchwasm"), []byte("malformed")})
+			Expect(result.Message).Should(ContainSubstring("-1"))
+			Expect(string(stub.State["batchwasm_a"])).Should(Equal("1"))
+		})
+	})
+})
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Index name for the history of changes of the channel configuration
+var configHistoryIndex = "wasmccConfigHistory"
+
+// channelConfig governs wasmcc on a channel. It is passed to Init when wasmcc is instantiated
+// and changed by updateConfig. Admins and runtime limits are the values of setAdmins and of
+// setRuntimeLimits without chaincode name.
+type channelConfig struct {
+	Admins []adminPrincipal `json:"admins,omitempty"`
+	// CreatorMSPs may create chaincodes, every MSP may if empty
+	CreatorMSPs []string `json:"creatorMSPs,omitempty"`
+	// RuntimeLimits is nil while the built in defaults apply
+	RuntimeLimits *runtimeLimits `json:"runtimeLimits,omitempty"`
+	// HostABIVersions modules may target, every version may if empty
+	HostABIVersions []int `json:"hostABIVersions,omitempty"`
+	// MaxModuleSize in bytes, unlimited if 0
+	MaxModuleSize int `json:"maxModuleSize,omitempty"`
+}
+
+// configChange is an entry of the configuration history.
+type configChange struct {
+	TxID      string         `json:"txId"`
+	Timestamp string         `json:"timestamp"`
+	MSPID     string         `json:"mspId"`
+	Config    *channelConfig `json:"config"`
+}
+
+func (c *channelConfig) validate() error {
+	for _, admin := range c.Admins {
+		if admin.MSPID == "" {
+			return fmt.Errorf("admin without MSP ID")
+		}
+	}
+	for _, mspID := range c.CreatorMSPs {
+		if mspID == "" {
+			return fmt.Errorf("empty creator MSP ID")
+		}
+	}
+	if c.RuntimeLimits != nil {
+		if err := c.RuntimeLimits.validate(); err != nil {
+			return err
+		}
+	}
+	for _, version := range c.HostABIVersions {
+		if version < 1 || version > latestHostABIVersion {
+			return fmt.Errorf("unknown host ABI version %d, expecting 1 to %d", version, latestHostABIVersion)
+		}
+	}
+	if c.MaxModuleSize < 0 {
+		return fmt.Errorf("maxModuleSize must not be negative")
+	}
+	return nil
+}
+
+// checkCreator rejects creators of chaincodes whose MSP may not create chaincodes.
+func (c *channelConfig) checkCreator(creator *identity) error {
+	if len(c.CreatorMSPs) == 0 {
+		return nil
+	}
+	for _, mspID := range c.CreatorMSPs {
+		if mspID == creator.mspID {
+			return nil
+		}
+	}
+	return fmt.Errorf(AccessDenied, "organization "+creator.mspID+" may not create chaincodes")
+}
+
+// checkModule rejects modules which are too large or target a host ABI version which is not
+// allowed.
+func (c *channelConfig) checkModule(code []byte) error {
+	if c.MaxModuleSize != 0 && len(code) > c.MaxModuleSize {
+		return fmt.Errorf(ModuleNotAllowed, fmt.Sprintf("%d bytes exceed limit of %d bytes", len(code), c.MaxModuleSize))
+	}
+	if len(c.HostABIVersions) == 0 {
+		return nil
+	}
+	version, err := hostABIVersion(code)
+	if err != nil {
+		return err
+	}
+	for _, allowed := range c.HostABIVersions {
+		if allowed == version {
+			return nil
+		}
+	}
+	return fmt.Errorf(ModuleNotAllowed, fmt.Sprintf("host ABI version %d is not allowed, expecting one of %v", version, c.HostABIVersions))
+}
+
+func configKey(stub shim.ChaincodeStubInterface, name string) (string, error) {
+	return stub.CreateCompositeKey(wasmccConfigIndex, []string{name})
+}
+
+// readConfigValue unmarshals a value of the configuration, leaving v unchanged if the value
+// is not set.
+func readConfigValue(stub shim.ChaincodeStubInterface, name string, v interface{}) error {
+	key, err := configKey(stub, name)
+	if err != nil {
+		return err
+	}
+	valueBytes, err := stub.GetState(key)
+	if err != nil || valueBytes == nil {
+		return err
+	}
+	return json.Unmarshal(valueBytes, v)
+}
+
+// writeConfigValue stores a value of the configuration, or deletes it if unset.
+func writeConfigValue(stub shim.ChaincodeStubInterface, name string, v interface{}, set bool) error {
+	key, err := configKey(stub, name)
+	if err != nil {
+		return err
+	}
+	if !set {
+		return stub.DelState(key)
+	}
+	valueBytes, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, valueBytes)
+}
+
+// loadChannelConfig returns the configuration in effect.
+func loadChannelConfig(stub shim.ChaincodeStubInterface) (*channelConfig, error) {
+	config := &channelConfig{}
+	var err error
+	if config.Admins, err = loadAdmins(stub); err != nil {
+		return nil, err
+	}
+	limits, found, err := readRuntimeLimits(stub, "")
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		config.RuntimeLimits = &limits
+	}
+	if err := readConfigValue(stub, "creatorMSPs", &config.CreatorMSPs); err != nil {
+		return nil, err
+	}
+	if err := readConfigValue(stub, "hostABIVersions", &config.HostABIVersions); err != nil {
+		return nil, err
+	}
+	if err := readConfigValue(stub, "maxModuleSize", &config.MaxModuleSize); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// storeChannelConfig stores a validated configuration, and records the change in the
+// configuration history.
+func storeChannelConfig(stub shim.ChaincodeStubInterface, config *channelConfig) error {
+	values := []struct {
+		name  string
+		value interface{}
+		set   bool
+	}{
+		{"admins", config.Admins, len(config.Admins) != 0},
+		{"runtimeLimits", config.RuntimeLimits, config.RuntimeLimits != nil},
+		{"creatorMSPs", config.CreatorMSPs, len(config.CreatorMSPs) != 0},
+		{"hostABIVersions", config.HostABIVersions, len(config.HostABIVersions) != 0},
+		{"maxModuleSize", config.MaxModuleSize, config.MaxModuleSize != 0},
+	}
+	for _, v := range values {
+		if err := writeConfigValue(stub, v.name, v.value, v.set); err != nil {
+			return err
+		}
+	}
+	return recordConfigChange(stub, config)
+}
+
+// recordConfigChange adds the configuration after a change to the history, keyed by the
+// transaction timestamp so the history is listed in order.
+func recordConfigChange(stub shim.ChaincodeStubInterface, config *channelConfig) error {
+	creator, err := creatorIdentity(stub)
+	if err != nil {
+		return err
+	}
+	at, timestamp, err := transactionTime(stub)
+	if err != nil {
+		return err
+	}
+
+	changeBytes, err := json.Marshal(&configChange{
+		TxID:      stub.GetTxID(),
+		Timestamp: timestamp,
+		MSPID:     creator.mspID,
+		Config:    config,
+	})
+	if err != nil {
+		return err
+	}
+	key, err := stub.CreateCompositeKey(configHistoryIndex, []string{fmt.Sprintf("%020d", at.UnixNano()), stub.GetTxID()})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, changeBytes)
+}
+
+// updateConfig changes the configuration of the channel. Receives the changed fields as json,
+// the fields left out keep their value.
+func (t *WASMChaincode) updateConfig(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting configuration as json")
+	}
+	if errResponse := checkAdmin(stub); errResponse != nil {
+		return *errResponse
+	}
+	return mergeConfig(stub, []byte(args[0]))
+}
+
+// mergeConfig replaces the fields of the configuration set in configJSON.
+func mergeConfig(stub shim.ChaincodeStubInterface, configJSON []byte) pb.Response {
+	config, err := loadChannelConfig(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	if err := json.Unmarshal(configJSON, config); err != nil {
+		return shim.Error(fmt.Sprintf(InvalidConfig, err.Error()))
+	}
+	if err := config.validate(); err != nil {
+		return shim.Error(fmt.Sprintf(InvalidConfig, err.Error()))
+	}
+	if err := storeChannelConfig(stub, config); err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+
+	configBytes, err := json.Marshal(config)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	logger.Infof("Configuration set to %s", configBytes)
+	return shim.Success(configBytes)
+}
+
+// config returns the configuration of the channel as json.
+func (t *WASMChaincode) config(stub shim.ChaincodeStubInterface) pb.Response {
+	config, err := loadChannelConfig(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	configBytes, err := json.Marshal(config)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	return shim.Success(configBytes)
+}
+
+// configHistory returns the changes of the configuration as json, oldest first.
+func (t *WASMChaincode) configHistory(stub shim.ChaincodeStubInterface) pb.Response {
+	iterator, err := stub.GetStateByPartialCompositeKey(configHistoryIndex, []string{})
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	defer iterator.Close()
+
+	changes := []json.RawMessage{}
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+		}
+		changes = append(changes, entry.Value)
+	}
+	changesBytes, err := json.Marshal(changes)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	return shim.Success(changesBytes)
+}
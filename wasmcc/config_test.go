@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tests for the channel configuration", func() {
+
+	status200 := int32(200)
+	status500 := int32(500)
+
+	initFunc := testFunc{name: "init", params: []byte{i64}, results: []byte{i64}, body: returnI64(0)}
+	v1Module := buildTestModule([]testImport{
+		{field: "__put_state", params: []byte{i32, i32, i32, i32}, results: []byte{i64}},
+	}, []testFunc{initFunc}, nil)
+	v2Module := buildTestModule([]testImport{
+		{field: "__set_response", params: []byte{i32, i32, i32, i32, i32}, results: []byte{i64}},
+	}, []testFunc{initFunc}, nil)
+	largeModule := buildTestModule(nil, []testFunc{initFunc}, make([]byte, 4096))
+
+	stub := shim.NewMockStub("configStub", new(WASMChaincode))
+	config := `{"admins":[{"mspId":"Org1MSP"}],"creatorMSPs":["Org1MSP"],"runtimeLimits":{"gasLimit":5000000},"hostABIVersions":[1],"maxModuleSize":2048}`
+	stub.MockInit("000", [][]byte{[]byte("init"), []byte(config)})
+
+	org1, _ := testIdentity("Org1MSP", "client")
+	org2, _ := testIdentity("Org2MSP", "client")
+
+	invokeAs := func(creator []byte, args ...[]byte) pb.Response {
+		stub.MockTransactionStart("config")
+		defer stub.MockTransactionEnd("config")
+		return new(WASMChaincode).Invoke(&identityStub{MockStub: stub, creator: creator, args: args})
+	}
+
+	It("should determine the host ABI version of modules", func() {
+		version, err := hostABIVersion(v1Module)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(version).Should(Equal(1))
+		version, err = hostABIVersion(v2Module)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(version).Should(Equal(2))
+	})
+	It("should be instantiated with a configuration", func() {
+		result := invokeAs(org2, []byte("config"))
+		Expect(result.Status).Should(Equal(status200))
+		Expect(string(result.Payload)).Should(Equal(config))
+
+		result = invokeAs(org2, []byte("runtimeLimits"))
+		Expect(string(result.Payload)).Should(ContainSubstring(`"gasLimit":5000000`))
+	})
+	It("should only let allowed organizations create chaincodes", func() {
+		result := invokeAs(org2, []byte("create"), []byte("configured"), v1Module)
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(ContainSubstring(`"code":409`))
+
+		result = invokeAs(org1, []byte("create"), []byte("configured"), v1Module)
+		Expect(result.Status).Should(Equal(status200), result.Message)
+	})
+	It("should reject modules not allowed by the configuration", func() {
+		result := invokeAs(org1, []byte("create"), []byte("newabi"), v2Module)
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(ContainSubstring(`"code":411`))
+		Expect(result.Message).Should(ContainSubstring("host ABI version 2 is not allowed"))
+
+		result = invokeAs(org1, []byte("upgrade"), []byte("configured"), largeModule)
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(ContainSubstring("exceed limit of 2048 bytes"))
+	})
+	It("should let admins update the configuration", func() {
+		update := []byte(`{"hostABIVersions":[1,2],"maxModuleSize":0}`)
+		result := invokeAs(org2, []byte("updateConfig"), update)
+		Expect(result.Status).Should(Equal(status500))
+		result = invokeAs(org1, []byte("updateConfig"), []byte(`{"hostABIVersions":[3]}`))
+		Expect(result.Status).Should(Equal(status500))
+
+		result = invokeAs(org1, []byte("updateConfig"), update)
+		Expect(result.Status).Should(Equal(status200), result.Message)
+		Expect(string(result.Payload)).Should(ContainSubstring(`"creatorMSPs":["Org1MSP"]`))
+		Expect(string(result.Payload)).ShouldNot(ContainSubstring("maxModuleSize"))
+
+		result = invokeAs(org1, []byte("create"), []byte("newabi"), v2Module)
+		Expect(result.Status).Should(Equal(status200), result.Message)
+	})
+	It("should keep the history of the configuration", func() {
+		result := invokeAs(org1, []byte("setRuntimeLimits"), []byte(`{"gasLimit":6000000}`))
+		Expect(result.Status).Should(Equal(status200), result.Message)
+
+		result = invokeAs(org2, []byte("configHistory"))
+		Expect(result.Status).Should(Equal(status200))
+		var changes []configChange
+		Expect(json.Unmarshal(result.Payload, &changes)).Should(Succeed())
+		Expect(changes).Should(HaveLen(3))
+		Expect(changes[0].MSPID).Should(BeEmpty())
+		Expect(changes[1].MSPID).Should(Equal("Org1MSP"))
+		Expect(changes[1].Config.HostABIVersions).Should(Equal([]int{1, 2}))
+		Expect(changes[2].Config.RuntimeLimits.GasLimit).Should(Equal(uint64(6000000)))
+	})
+})
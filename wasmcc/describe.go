@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Section ids decoded by describe besides those walked for symbols
+const (
+	wasmTypeSectionID     = 1
+	wasmFunctionSectionID = 3
+	wasmTableSectionID    = 4
+	wasmMemorySectionID   = 5
+)
+
+var valueTypeNames = map[byte]string{
+	0x7f: "i32",
+	0x7e: "i64",
+	0x7d: "f32",
+	0x7c: "f64",
+	0x7b: "v128",
+	0x70: "funcref",
+	0x6f: "externref",
+}
+
+// functionSignature is the type of a wasm function.
+type functionSignature struct {
+	Params  []string `json:"params"`
+	Results []string `json:"results"`
+}
+
+// wasmLimits is the size of a memory, in pages of 64 KiB, or of a table. Max is nil when the
+// size is unbounded.
+type wasmLimits struct {
+	Min uint32  `json:"min"`
+	Max *uint32 `json:"max,omitempty"`
+}
+
+// importDescription is an import with the signature of an imported function, or the limits
+// of an imported memory or table.
+type importDescription struct {
+	wasmImport
+	Signature *functionSignature `json:"signature,omitempty"`
+	Limits    *wasmLimits        `json:"limits,omitempty"`
+}
+
+// exportDescription is an export with the signature of an exported function, or the limits
+// of an exported memory or table.
+type exportDescription struct {
+	Name      string             `json:"name"`
+	Kind      string             `json:"kind"`
+	Signature *functionSignature `json:"signature,omitempty"`
+	Limits    *wasmLimits        `json:"limits,omitempty"`
+}
+
+// moduleDescription is what describe tells about a wasm module. Memories and Tables are
+// those the module defines, imported ones are listed with the imports.
+type moduleDescription struct {
+	Name           string              `json:"name"`
+	CodeHash       string              `json:"codeHash"`
+	Size           int                 `json:"size"`
+	HostABIVersion int                 `json:"hostABIVersion"`
+	Imports        []importDescription `json:"imports"`
+	Exports        []exportDescription `json:"exports"`
+	Memories       []wasmLimits        `json:"memories"`
+	Tables         []wasmLimits        `json:"tables"`
+	CustomSections []string            `json:"customSections"`
+}
+
+func (r *wasmReader) valueTypes() []string {
+	count := int(r.u32())
+	types := []string{}
+	for i := 0; i < count && r.err == nil; i++ {
+		b := r.byte()
+		name, ok := valueTypeNames[b]
+		if !ok {
+			name = fmt.Sprintf("0x%02x", b)
+		}
+		types = append(types, name)
+	}
+	return types
+}
+
+func (r *wasmReader) sizeLimits() *wasmLimits {
+	min, max, bounded := r.limits()
+	limits := &wasmLimits{Min: min}
+	if bounded {
+		limits.Max = &max
+	}
+	return limits
+}
+
+// describeModule decodes the imports, exports, memories, tables and custom sections of a
+// module.
+func describeModule(code []byte) (*moduleDescription, error) {
+	sections, err := readWasmSections(code)
+	if err != nil {
+		return nil, err
+	}
+	version, err := hostABIVersion(code)
+	if err != nil {
+		return nil, err
+	}
+
+	description := &moduleDescription{
+		CodeHash:       codeHash(code),
+		Size:           len(code),
+		HostABIVersion: version,
+		Imports:        []importDescription{},
+		Exports:        []exportDescription{},
+		Memories:       []wasmLimits{},
+		Tables:         []wasmLimits{},
+		CustomSections: []string{},
+	}
+
+	// Index spaces of the module, imports come first
+	var types []functionSignature
+	var functions []uint32
+	var tables, memories []*wasmLimits
+	signature := func(typeIndex uint32) (*functionSignature, error) {
+		if int(typeIndex) >= len(types) {
+			return nil, errMalformedWasm
+		}
+		return &types[typeIndex], nil
+	}
+
+	for _, section := range sections {
+		r := &wasmReader{buf: section.Payload}
+		switch section.ID {
+		case wasmCustomSectionID:
+			description.CustomSections = append(description.CustomSections, section.Name)
+		case wasmTypeSectionID:
+			count := int(r.u32())
+			for i := 0; i < count && r.err == nil; i++ {
+				if r.byte() != 0x60 {
+					return nil, errMalformedWasm
+				}
+				types = append(types, functionSignature{Params: r.valueTypes(), Results: r.valueTypes()})
+			}
+		case wasmImportSectionID:
+			count := int(r.u32())
+			for i := 0; i < count && r.err == nil; i++ {
+				entry := importDescription{wasmImport: wasmImport{Module: r.name(), Name: r.name()}}
+				kind := r.byte()
+				switch kind {
+				case 0: // function
+					typeIndex := r.u32()
+					functions = append(functions, typeIndex)
+					if r.err == nil {
+						if entry.Signature, err = signature(typeIndex); err != nil {
+							return nil, err
+						}
+					}
+				case 1: // table
+					r.byte()
+					entry.Limits = r.sizeLimits()
+					tables = append(tables, entry.Limits)
+				case 2: // memory
+					entry.Limits = r.sizeLimits()
+					memories = append(memories, entry.Limits)
+				case 3: // global
+					r.byte()
+					r.byte()
+				default:
+					return nil, errMalformedWasm
+				}
+				entry.Kind = importKinds[kind]
+				description.Imports = append(description.Imports, entry)
+			}
+		case wasmFunctionSectionID:
+			count := int(r.u32())
+			for i := 0; i < count && r.err == nil; i++ {
+				functions = append(functions, r.u32())
+			}
+		case wasmTableSectionID:
+			count := int(r.u32())
+			for i := 0; i < count && r.err == nil; i++ {
+				r.byte()
+				limits := r.sizeLimits()
+				tables = append(tables, limits)
+				description.Tables = append(description.Tables, *limits)
+			}
+		case wasmMemorySectionID:
+			count := int(r.u32())
+			for i := 0; i < count && r.err == nil; i++ {
+				limits := r.sizeLimits()
+				memories = append(memories, limits)
+				description.Memories = append(description.Memories, *limits)
+			}
+		case wasmExportSectionID:
+			count := int(r.u32())
+			for i := 0; i < count && r.err == nil; i++ {
+				entry := exportDescription{Name: r.name()}
+				kind := r.byte()
+				index := int(r.u32())
+				if r.err != nil {
+					break
+				}
+				switch kind {
+				case 0: // function
+					if index >= len(functions) {
+						return nil, errMalformedWasm
+					}
+					if entry.Signature, err = signature(functions[index]); err != nil {
+						return nil, err
+					}
+				case 1: // table
+					if index >= len(tables) {
+						return nil, errMalformedWasm
+					}
+					entry.Limits = tables[index]
+				case 2: // memory
+					if index >= len(memories) {
+						return nil, errMalformedWasm
+					}
+					entry.Limits = memories[index]
+				case 3: // global
+				default:
+					return nil, errMalformedWasm
+				}
+				entry.Kind = importKinds[kind]
+				description.Exports = append(description.Exports, entry)
+			}
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+	return description, nil
+}
+
+// describe returns the imports, exports with their signatures, memory and table limits,
+// custom sections and the host ABI version of a wasm chaincode as json. Receives a chaincode
+// reference, name[@version|:tag][#sha256], describing the active version by default.
+func (t *WASMChaincode) describe(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode name")
+	}
+	ref, err := parseChaincodeRef(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	code, errResponse := loadChaincodeCode(stub, ref)
+	if errResponse != nil {
+		return *errResponse
+	}
+	if err := ref.checkCodeHash(code); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	description, err := describeModule(code)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	description.Name = ref.name
+	descriptionBytes, err := json.Marshal(description)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	return shim.Success(descriptionBytes)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tests for describing wasm modules", func() {
+
+	status200 := int32(200)
+	status500 := int32(500)
+
+	module := buildTestModule([]testImport{
+		{field: "__put_state", params: []byte{i32, i32, i32, i32}, results: []byte{i64}},
+	}, []testFunc{
+		{name: "init", params: []byte{i64}, results: []byte{i64}, body: returnI64(0)},
+		{name: "query", params: []byte{i64, i32}, results: []byte{i64}, body: returnI64(0)},
+	}, nil, customSection("producers", []byte{0}))
+
+	stub := shim.NewMockStub("describeStub", new(WASMChaincode))
+	stub.MockInit("000", nil)
+
+	It("should describe the imports, exports and sections of a module", func() {
+		description, err := describeModule(module)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(description.CodeHash).Should(Equal(codeHash(module)))
+		Expect(description.Size).Should(Equal(len(module)))
+		Expect(description.HostABIVersion).Should(Equal(1))
+
+		Expect(description.Imports).Should(HaveLen(1))
+		Expect(description.Imports[0].wasmImport).Should(Equal(wasmImport{Module: "env", Name: "__put_state", Kind: "function"}))
+		Expect(*description.Imports[0].Signature).Should(Equal(functionSignature{
+			Params:  []string{"i32", "i32", "i32", "i32"},
+			Results: []string{"i64"},
+		}))
+
+		Expect(description.Exports).Should(HaveLen(3))
+		Expect(description.Exports[1].Name).Should(Equal("query"))
+		Expect(description.Exports[1].Kind).Should(Equal("function"))
+		Expect(*description.Exports[1].Signature).Should(Equal(functionSignature{
+			Params:  []string{"i64", "i32"},
+			Results: []string{"i64"},
+		}))
+		Expect(description.Exports[2].Kind).Should(Equal("memory"))
+		Expect(*description.Exports[2].Limits).Should(Equal(wasmLimits{Min: 1}))
+
+		Expect(description.Memories).Should(Equal([]wasmLimits{{Min: 1}}))
+		Expect(description.Tables).Should(BeEmpty())
+		Expect(description.CustomSections).Should(Equal([]string{"name", "producers"}))
+	})
+	It("should reject malformed modules", func() {
+		_, err := describeModule(module[:len(module)-3])
+		Expect(err).Should(HaveOccurred())
+	})
+	It("should describe installed chaincodes", func() {
+		result := stub.MockInvoke("000", [][]byte{[]byte("create"), []byte("described"), module})
+		Expect(result.Status).Should(Equal(status200), result.Message)
+
+		result = stub.MockInvoke("000", [][]byte{[]byte("describe"), []byte("described@1")})
+		Expect(result.Status).Should(Equal(status200), result.Message)
+		description := moduleDescription{}
+		Expect(json.Unmarshal(result.Payload, &description)).Should(Succeed())
+		Expect(description.Name).Should(Equal("described"))
+		Expect(description.Exports).Should(HaveLen(3))
+		Expect(string(result.Payload)).Should(ContainSubstring(`"module":"env","name":"__put_state","kind":"function","signature"`))
+
+		result = stub.MockInvoke("000", [][]byte{[]byte("describe"), []byte("described@2")})
+		Expect(result.Status).Should(Equal(status500))
+		result = stub.MockInvoke("000", [][]byte{[]byte("describe"), []byte("described#" + codeHash(nil))})
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(ContainSubstring(`"code":407`))
+	})
+})
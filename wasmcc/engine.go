@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// defaultEngine runs wasm chaincode until an administrator selects another engine.
+const defaultEngine = "life"
+
+// wasmEngine validates, compiles and instantiates wasm modules.
+type wasmEngine interface {
+	// validate checks that the code is a module the engine can run.
+	validate(code []byte) error
+	// load returns the compiled module for the code. Engines may cache compiled modules.
+	load(code []byte, limits runtimeLimits) (wasmModule, error)
+}
+
+// wasmModule is a compiled module.
+type wasmModule interface {
+	// instantiate creates an instance whose imports are served by the resolver.
+	instantiate(limits runtimeLimits, r *Resolver) (wasmInstance, error)
+}
+
+// wasmInstance is an instance of a module used by a single invocation.
+type wasmInstance interface {
+	hostEnv
+	// call runs an exported function. Found is false if the module does not export it.
+	call(function string, args ...int64) (result int64, found bool, err error)
+	// gasUsed returns the fuel used by the instance, including host calls.
+	gasUsed() uint64
+	// stackTrace describes the wasm call stack of the last trap.
+	stackTrace(symbols *moduleSymbols) string
+	// close releases the instance.
+	close()
+}
+
+// hostEnv is the view host functions have of the instance calling them.
+type hostEnv interface {
+	// memory returns the linear memory of the instance. It is only valid until the
+	// instance runs again, as growing memory may move it.
+	memory() []byte
+	// addGas charges gas to the instance and panics when its gas limit is exceeded.
+	addGas(gas uint64)
+}
+
+// hostFunc implements a host function for every engine. Parameters are passed as int64
+// whatever their wasm type.
+type hostFunc func(env hostEnv, params []int64) int64
+
+var wasmEngines = make(map[string]wasmEngine)
+
+// registerEngine makes an engine selectable by its name. Engines register themselves from
+// init functions, some only in builds with the corresponding build tag.
+func registerEngine(name string, engine wasmEngine) {
+	if _, ok := wasmEngines[name]; ok {
+		panic(fmt.Errorf("wasm engine %s registered twice", name))
+	}
+	wasmEngines[name] = engine
+}
+
+// engineNames returns the names of the engines available in this build.
+func engineNames() []string {
+	names := make([]string, 0, len(wasmEngines))
+	for name := range wasmEngines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupEngine(name string) (wasmEngine, error) {
+	engine, ok := wasmEngines[name]
+	if !ok {
+		return nil, fmt.Errorf("wasm engine %s is not available, expecting one of %s", name, strings.Join(engineNames(), ", "))
+	}
+	return engine, nil
+}
+
+func engineKey(stub shim.ChaincodeStubInterface) (string, error) {
+	return stub.CreateCompositeKey(wasmccConfigIndex, []string{"engine"})
+}
+
+// loadEngineName reads the name of the engine selected for the channel.
+func loadEngineName(stub shim.ChaincodeStubInterface) (string, error) {
+	key, err := engineKey(stub)
+	if err != nil {
+		return "", err
+	}
+	nameBytes, err := stub.GetState(key)
+	if err != nil {
+		return "", err
+	}
+	if nameBytes == nil {
+		return defaultEngine, nil
+	}
+	return string(nameBytes), nil
+}
+
+// loadEngine returns the engine selected for the channel. All peers of a channel must be
+// built with the selected engine, otherwise they fail to endorse.
+func loadEngine(stub shim.ChaincodeStubInterface) (wasmEngine, error) {
+	name, err := loadEngineName(stub)
+	if err != nil {
+		return nil, err
+	}
+	return lookupEngine(name)
+}
+
+// setEngine selects the engine running the wasm chaincodes of the channel.
+func (t *WASMChaincode) setEngine(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting engine name")
+	}
+	if errResponse := checkAdmin(stub); errResponse != nil {
+		return *errResponse
+	}
+	if _, err := lookupEngine(args[0]); err != nil {
+		return shim.Error(fmt.Sprintf(InvalidConfig, err.Error()))
+	}
+
+	key, err := engineKey(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	if err := stub.PutState(key, []byte(args[0])); err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+
+	logger.Infof("Wasm engine set to %s", args[0])
+	return shim.Success([]byte(args[0]))
+}
+
+// engine returns the name of the engine selected for the channel.
+func (t *WASMChaincode) engine(stub shim.ChaincodeStubInterface) pb.Response {
+	name, err := loadEngineName(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	return shim.Success([]byte(name))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/perlin-network/life/exec"
+	wasm_validation "github.com/perlin-network/life/wasm-validation"
+)
+
+func init() {
+	registerEngine("life", lifeEngine{})
+}
+
+// lifeEngine runs modules with the life interpreter, or with natively compiled code in
+// builds with ahead-of-time compilation. It charges gas for every wasm instruction.
+type lifeEngine struct{}
+
+func (lifeEngine) validate(code []byte) error {
+	return wasm_validation.ValidateWasm(code)
+}
+
+func (lifeEngine) load(code []byte, limits runtimeLimits) (wasmModule, error) {
+	config := limits.vmConfig()
+	key := newModuleCacheKey(code, config)
+	module, err := loadModule(key, code, config, &Resolver{})
+	if err != nil {
+		//life appends a go traceback to instantiation errors
+		return nil, errors.New(strings.SplitN(strings.TrimPrefix(err.Error(), "Error: "), "\n", 2)[0])
+	}
+	return &lifeModule{key: key, module: module}, nil
+}
+
+// lifeModule is a module compiled by life, shared by all its instances.
+type lifeModule struct {
+	key    moduleCacheKey
+	module *exec.Module
+}
+
+func (m *lifeModule) instantiate(limits runtimeLimits, r *Resolver) (wasmInstance, error) {
+	// Take a vm of the shared module with the limits of this invocation from the pool, and
+	// resolve imports of this invocation.
+	pool, err := vmPoolFor(m.key, m.module, limits.vmConfig())
+	if err != nil {
+		return nil, err
+	}
+	vm, err := pool.get()
+	if err != nil {
+		return nil, err
+	}
+	vm.ImportResolver = r
+
+	//Run natively compiled code instead of interpreting it, when available
+	release := attachNativeCode(vm, m.key, m.module)
+	return &lifeInstance{vm: vm, pool: pool, release: release}, nil
+}
+
+// lifeInstance is a life vm running one invocation.
+type lifeInstance struct {
+	vm      *exec.VirtualMachine
+	pool    *vmPool
+	release func()
+}
+
+func (i *lifeInstance) call(function string, args ...int64) (int64, bool, error) {
+	entryID, ok := i.vm.GetFunctionExport(function)
+	if !ok {
+		return -1, false, nil
+	}
+	result, err := i.vm.Run(entryID, args...)
+	return result, true, err
+}
+
+func (i *lifeInstance) memory() []byte {
+	return i.vm.Memory
+}
+
+func (i *lifeInstance) addGas(gas uint64) {
+	i.vm.AddAndCheckGas(gas)
+}
+
+func (i *lifeInstance) gasUsed() uint64 {
+	return i.vm.Gas
+}
+
+func (i *lifeInstance) stackTrace(symbols *moduleSymbols) string {
+	return stackTrace(i.vm, symbols)
+}
+
+func (i *lifeInstance) close() {
+	i.release()
+	i.pool.put(i.vm)
+}
+
+// lifeHostEnv lets host functions called by a life vm access it.
+type lifeHostEnv struct {
+	vm *exec.VirtualMachine
+}
+
+func (e lifeHostEnv) memory() []byte {
+	return e.vm.Memory
+}
+
+func (e lifeHostEnv) addGas(gas uint64) {
+	e.vm.AddAndCheckGas(gas)
+}
+
+// ResolveFunc defines a set of import functions that may be called within a WebAssembly module.
+func (r *Resolver) ResolveFunc(module, field string) exec.FunctionImport {
+	f := r.hostFunction(module, field)
+	return func(vm *exec.VirtualMachine) int64 {
+		return f(lifeHostEnv{vm}, vm.GetCurrentFrame().Locals)
+	}
+}
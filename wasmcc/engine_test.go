@@ -0,0 +1,46 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// opI64Extend8S is a sign extension instruction, emitted by current toolchains but not
+// supported by life.
+const opI64Extend8S = 0xc2
+
+var signExtensionModule = buildTestModule(nil, []testFunc{
+	{name: "init", params: []byte{i64}, results: []byte{i64}, body: returnI64(0)},
+	{name: "extend", params: []byte{i64}, results: []byte{i64}, body: concat(returnI64(0x80), []byte{opI64Extend8S})},
+}, nil)
+
+var _ = Describe("Tests for wasm engine selection", func() {
+
+	status200 := int32(200)
+	status500 := int32(500)
+
+	stub := newAdminStub("engineStub")
+
+	It("should run chaincode with life by default", func() {
+		result := stub.MockInvoke("000", [][]byte{[]byte("engine")})
+		Expect(result.Status).Should(Equal(status200))
+		Expect(string(result.Payload)).Should(Equal("life"))
+
+		result = stub.MockInvoke("000",
+			[][]byte{[]byte("create"), []byte("lifewasm"), ReadAssetTransferWASM(),
+				[]byte("account1"), []byte("100"), []byte("account2"), []byte("1000")})
+		Expect(result.Status).Should(Equal(status200))
+	})
+	It("should reject engines missing from the build", func() {
+		result := invokeAsAdmin(stub, []byte("setEngine"), []byte("wasmtime"))
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(ContainSubstring(`"code":405`))
+		Expect(result.Message).Should(ContainSubstring("life"))
+	})
+	It("should not create modules using instructions life does not support", func() {
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("create"), []byte("signextwasm"), signExtensionModule})
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(ContainSubstring(`"code":404`))
+	})
+})
@@ -0,0 +1,253 @@
+//go:build wazero
+// +build wazero
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+func init() {
+	registerEngine("wazero", &wazeroEngine{cache: wazero.NewCompilationCache()})
+}
+
+// wazeroEngine runs modules with wazero, which supports the wasm features emitted by current
+// toolchains, e.g. sign extension, bulk memory and multi value. Modules are metered before
+// they are compiled, as wazero does not charge gas itself. Each invocation gets its own
+// runtime, compiled code is shared through the compilation cache.
+type wazeroEngine struct {
+	cache wazero.CompilationCache
+}
+
+func (e *wazeroEngine) newRuntime(ctx context.Context, limits runtimeLimits) wazero.Runtime {
+	config := wazero.NewRuntimeConfig().
+		WithCompilationCache(e.cache).
+		WithMemoryLimitPages(uint32(limits.MaxMemoryPages))
+	return wazero.NewRuntimeWithConfig(ctx, config)
+}
+
+// validate checks the module as it was deployed before checking the metered module, so only
+// valid modules are metered.
+func (e *wazeroEngine) validate(code []byte) error {
+	ctx := context.Background()
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfigInterpreter())
+	defer runtime.Close(ctx)
+
+	if _, err := runtime.CompileModule(ctx, code); err != nil {
+		return err
+	}
+	_, err := e.compile(code, defaultRuntimeLimits())
+	return err
+}
+
+// compile meters a module with the limits and compiles it to check it is valid.
+func (e *wazeroEngine) compile(code []byte, limits runtimeLimits) ([]byte, error) {
+	metered, err := meterModule(code, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	runtime := e.newRuntime(ctx, limits)
+	defer runtime.Close(ctx)
+
+	_, err = runtime.CompileModule(ctx, metered)
+	return metered, err
+}
+
+func (e *wazeroEngine) load(code []byte, limits runtimeLimits) (wasmModule, error) {
+	metered, err := e.compile(code, limits)
+	if err != nil {
+		return nil, err
+	}
+	return &wazeroModule{engine: e, code: metered}, nil
+}
+
+// wazeroModule is a validated module metered with the limits it was loaded with. It is
+// compiled again by the runtime of each instance, which finds the compiled code in the cache
+// of the engine.
+type wazeroModule struct {
+	engine *wazeroEngine
+	code   []byte
+}
+
+func (m *wazeroModule) instantiate(limits runtimeLimits, r *Resolver) (_ wasmInstance, retErr error) {
+	ctx := context.Background()
+	runtime := m.engine.newRuntime(ctx, limits)
+	instance := &wazeroInstance{ctx: ctx, runtime: runtime}
+	defer func() {
+		if retErr != nil {
+			instance.close()
+		}
+	}()
+
+	compiled, err := runtime.CompileModule(ctx, m.code)
+	if err != nil {
+		return nil, err
+	}
+	if err := instance.instantiateHostFunctions(compiled, r); err != nil {
+		return nil, err
+	}
+	instance.module, err = runtime.InstantiateModule(ctx, compiled, wazero.NewModuleConfig().WithStartFunctions())
+	if err != nil {
+		return nil, instance.trap(err)
+	}
+	instance.gasLeft = instance.module.ExportedGlobal(meteringGasExport).(api.MutableGlobal)
+	instance.trapReason = instance.module.ExportedGlobal(meteringTrapExport)
+	instance.gasLimit = instance.gasLeft.Get()
+
+	// the start function and the initializer of reactor modules run for every instance, as
+	// wazero cannot snapshot initialized modules. Like with life, their gas is not charged to
+	// the invocation.
+	instance.initializing = true
+	for _, name := range []string{meteringStartExport, moduleInitializer} {
+		if _, _, err := instance.call(name); err != nil {
+			return nil, fmt.Errorf("%s failed: %s", name, gasError(err, limits.GasLimit))
+		}
+	}
+	instance.initializing = false
+	instance.gasLeft.Set(instance.gasLimit)
+	return instance, nil
+}
+
+// wazeroInstance is a module instantiated in its own wazero runtime.
+type wazeroInstance struct {
+	ctx        context.Context
+	runtime    wazero.Runtime
+	module     api.Module
+	caller     api.Module
+	gasLeft    api.MutableGlobal
+	gasLimit   uint64
+	trapReason api.Global
+	trace      string
+
+	// host functions cannot be called while the module initializes
+	initializing bool
+}
+
+// instantiateHostFunctions instantiates the host functions imported by the module, with the
+// signatures the module declares for them.
+func (i *wazeroInstance) instantiateHostFunctions(compiled wazero.CompiledModule, r *Resolver) (retErr error) {
+	//the resolver panics on unknown imports
+	defer func() {
+		if err := recover(); err != nil {
+			retErr = fmt.Errorf("%v", err)
+		}
+	}()
+
+	builder := i.runtime.NewHostModuleBuilder("env")
+	for _, def := range compiled.ImportedFunctions() {
+		moduleName, field, _ := def.Import()
+		if moduleName != "env" {
+			return fmt.Errorf("unknown module: %s", moduleName)
+		}
+		builder.NewFunctionBuilder().
+			WithGoModuleFunction(i.hostFunction(r.hostFunction(moduleName, field), def), def.ParamTypes(), def.ResultTypes()).
+			Export(field)
+	}
+	_, err := builder.Instantiate(i.ctx)
+	return err
+}
+
+func (i *wazeroInstance) hostFunction(f hostFunc, def api.FunctionDefinition) api.GoModuleFunc {
+	numParams := len(def.ParamTypes())
+	results := def.ResultTypes()
+	return func(ctx context.Context, caller api.Module, stack []uint64) {
+		if i.initializing {
+			panic(errors.New("host functions cannot be called by " + moduleInitializer))
+		}
+
+		params := make([]int64, numParams)
+		for j := range params {
+			params[j] = int64(stack[j])
+		}
+		i.caller = caller
+		result := f(i, params)
+
+		if len(results) > 0 {
+			if results[0] == api.ValueTypeI32 {
+				stack[0] = uint64(uint32(result))
+			} else {
+				stack[0] = uint64(result)
+			}
+		}
+	}
+}
+
+func (i *wazeroInstance) call(function string, args ...int64) (int64, bool, error) {
+	f := i.module.ExportedFunction(function)
+	if f == nil {
+		return -1, false, nil
+	}
+
+	params := make([]uint64, len(args))
+	for j, arg := range args {
+		params[j] = uint64(arg)
+	}
+	results, err := f.Call(i.ctx, params...)
+	if err != nil {
+		return -1, true, i.trap(err)
+	}
+	if len(results) == 0 {
+		return 0, true, nil
+	}
+	if f.Definition().ResultTypes()[0] == api.ValueTypeI32 {
+		return int64(int32(results[0])), true, nil
+	}
+	return int64(results[0]), true, nil
+}
+
+// trap separates the wasm stack trace wazero appends to errors from the error itself, and
+// reports why the metering code trapped.
+func (i *wazeroInstance) trap(err error) error {
+	parts := strings.SplitN(err.Error(), "\nwasm stack trace:", 2)
+	if len(parts) == 2 {
+		i.trace = "wasm stack trace:" + strings.Replace(parts[1], "\n\t", "\n  ", -1)
+	}
+	if i.trapReason != nil {
+		if message, ok := meteringTrapMessages[uint32(i.trapReason.Get())]; ok {
+			return errors.New(message)
+		}
+	}
+	return errors.New(strings.TrimSuffix(parts[0], " (recovered by wazero)"))
+}
+
+func (i *wazeroInstance) memory() []byte {
+	memory := i.caller.Memory()
+	if memory == nil {
+		return nil
+	}
+	view, _ := memory.Read(0, memory.Size())
+	return view
+}
+
+// addGas charges host calls against the gas left of the metered module.
+func (i *wazeroInstance) addGas(gas uint64) {
+	left := i.gasLeft.Get()
+	if gas > left {
+		i.gasLeft.Set(0)
+		panic(errors.New(lifeGasLimitExceeded))
+	}
+	i.gasLeft.Set(left - gas)
+}
+
+func (i *wazeroInstance) gasUsed() uint64 {
+	if i.gasLeft == nil {
+		return 0
+	}
+	return i.gasLimit - i.gasLeft.Get()
+}
+
+func (i *wazeroInstance) stackTrace(symbols *moduleSymbols) string {
+	return i.trace
+}
+
+func (i *wazeroInstance) close() {
+	i.runtime.Close(context.Background())
+}
@@ -0,0 +1,207 @@
+//go:build wazero
+// +build wazero
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tests for wasm chaincode on wazero", func() {
+
+	status200 := int32(200)
+	status500 := int32(500)
+
+	spinningModule := buildTestModule(nil, []testFunc{
+		{name: "init", params: []byte{i64}, results: []byte{i64}, body: returnI64(0)},
+		{name: "spin", params: []byte{i64}, results: []byte{i64}, body: []byte{opLoop, 0x40, opBr, 0, opEnd, opUnreachable}},
+	}, nil)
+
+	// newWazeroStub returns a stub of a channel which selected wazero.
+	newWazeroStub := func() *shim.MockStub {
+		stub := newAdminStub("wazeroStub")
+		result := invokeAsAdmin(stub, []byte("setEngine"), []byte("wazero"))
+		Expect(result.Status).Should(Equal(status200))
+		return stub
+	}
+
+	It("should give the same results as life", func() {
+		invocations := [][][]byte{
+			{[]byte("create"), []byte("balancewasm"), ReadAssetTransferWASM(),
+				[]byte("account1"), []byte("100"), []byte("account2"), []byte("1000")},
+			{[]byte("execute"), []byte("balancewasm"), []byte("invoke"), []byte("account2"), []byte("account1"), []byte("10")},
+			{[]byte("execute"), []byte("balancewasm"), []byte("query"), []byte("account1")},
+			{[]byte("execute"), []byte("balancewasm"), []byte("query"), []byte("account3")},
+			{[]byte("execute"), []byte("balancewasm"), []byte("missing")},
+		}
+
+		lifeStub := shim.NewMockStub("lifeStub", new(WASMChaincode))
+		lifeStub.MockInit("000", nil)
+		wazeroStub := newWazeroStub()
+		for _, args := range invocations {
+			expected := lifeStub.MockInvoke("000", args)
+			result := wazeroStub.MockInvoke("000", args)
+			Expect(result.Status).Should(Equal(expected.Status))
+			Expect(result.Payload).Should(Equal(expected.Payload))
+		}
+	})
+	It("should run modules using sign extension", func() {
+		stub := newWazeroStub()
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("create"), []byte("signextwasm"), signExtensionModule})
+		Expect(result.Status).Should(Equal(status200))
+
+		result = stub.MockInvoke("000",
+			[][]byte{[]byte("execute"), []byte("signextwasm"), []byte("extend")})
+		Expect(result.Status).Should(Equal(status200))
+		Expect(string(result.Payload)).Should(Equal("-128"))
+	})
+	It("should run the initializer of reactor modules", func() {
+		stub := newWazeroStub()
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("create"), []byte("reactorwasm"), reactorModule})
+		Expect(result.Status).Should(Equal(status200))
+
+		result = stub.MockInvoke("000",
+			[][]byte{[]byte("execute"), []byte("reactorwasm"), []byte("count")})
+		Expect(result.Status).Should(Equal(status200))
+		Expect(string(result.Payload)).Should(Equal("101"))
+	})
+	It("should charge host calls against the gas limit", func() {
+		stub := newWazeroStub()
+		result := invokeAsAdmin(stub, []byte("setRuntimeLimits"), []byte(`{"gasLimit":1000}`))
+		Expect(result.Status).Should(Equal(status200))
+
+		result = stub.MockInvoke("000",
+			[][]byte{[]byte("create"), []byte("balancewasm"), ReadAssetTransferWASM(),
+				[]byte("account1"), []byte("100"), []byte("account2"), []byte("1000")})
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(ContainSubstring("out of gas: gas limit of 1000 exceeded"))
+	})
+	It("should abort an infinite loop with out of gas", func() {
+		stub := newWazeroStub()
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("create"), []byte("spinwasm"), spinningModule})
+		Expect(result.Status).Should(Equal(status200))
+
+		result = stub.MockInvoke("000",
+			[][]byte{[]byte("execute"), []byte("spinwasm"), []byte("spin")})
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(ContainSubstring("out of gas: gas limit of 100000000 exceeded"))
+		Expect(reportedGasUsed(stub)).Should(Equal(uint64(100000000)))
+	})
+	It("should charge the same gas on every run", func() {
+		stub := newWazeroStub()
+		create := [][]byte{[]byte("create"), []byte("balancewasm"), ReadAssetTransferWASM(),
+			[]byte("account1"), []byte("100"), []byte("account2"), []byte("1000")}
+		result := stub.MockInvoke("000", create)
+		Expect(result.Status).Should(Equal(status200))
+
+		execute := [][]byte{[]byte("execute"), []byte("balancewasm"), []byte("query"), []byte("account1")}
+		first := stub.MockInvoke("000", execute)
+		Expect(first.Status).Should(Equal(status200))
+		firstGasUsed := reportedGasUsed(stub)
+		Expect(stub.MockInvoke("000", execute).Status).Should(Equal(status200))
+		Expect(reportedGasUsed(stub)).Should(Equal(firstGasUsed))
+	})
+	It("should charge bulk memory instructions by the bytes they move", func() {
+		fill := func(bytes byte) []byte {
+			return buildTestModule(nil, []testFunc{
+				{name: "init", params: []byte{i64}, results: []byte{i64}, body: concat(
+					[]byte{opI32Const, 0, opI32Const, 0, opI32Const, 0x80, 0x80, bytes, 0xfc, 11, 0},
+					returnI64(0))},
+			}, nil)
+		}
+		stub := newWazeroStub()
+		small := stub.MockInvoke("000", [][]byte{[]byte("create"), []byte("fillsmall"), fill(0)})
+		Expect(small.Status).Should(Equal(status200), small.Message)
+		smallGas := reportedGasUsed(stub)
+		large := stub.MockInvoke("000", [][]byte{[]byte("create"), []byte("filllarge"), fill(1)})
+		Expect(large.Status).Should(Equal(status200), large.Message)
+		largeGas := reportedGasUsed(stub)
+		Expect(largeGas - smallGas).Should(Equal(uint64(16384 >> bulkMemoryBytesPerGasShift)))
+	})
+	It("should enforce the call stack depth and value slot limits like life", func() {
+		recursive := buildTestModule(nil, []testFunc{
+			{name: "init", params: []byte{i64}, results: []byte{i64}, body: returnI64(0)},
+			{name: "recurse", params: []byte{i64}, results: []byte{i64}, body: []byte{opLocalGet, 0, opCall, 1}},
+		}, nil)
+		// life reports the same errors, unless its native code runs, which checks the value
+		// slots of calls differently
+		for limits, expected := range map[string]string{
+			`{"maxCallStackDepth":100}`: "max call stack depth exceeded",
+			`{"maxValueSlots":100}`:     "max value slot count exceeded",
+		} {
+			stub := newWazeroStub()
+			result := invokeAsAdmin(stub, []byte("setRuntimeLimits"), []byte(limits))
+			Expect(result.Status).Should(Equal(status200))
+			result = stub.MockInvoke("000", [][]byte{[]byte("create"), []byte("recursewasm"), recursive})
+			Expect(result.Status).Should(Equal(status200), result.Message)
+
+			result = stub.MockInvoke("000", [][]byte{[]byte("execute"), []byte("recursewasm"), []byte("recurse")})
+			Expect(result.Status).Should(Equal(status500))
+			Expect(result.Message).Should(HavePrefix(fmt.Sprintf(ExecutionFailed, expected)))
+		}
+	})
+	It("should run the start function of modules", func() {
+		counter := buildTestModule(nil, []testFunc{
+			{name: "init", params: []byte{i64}, results: []byte{i64}, body: returnI64(0)},
+			{name: "count", params: []byte{i64}, results: []byte{i64}, body: []byte{opI32Const, 0, 0x31, 0, 0}},
+			{params: nil, results: nil, body: []byte{opI32Const, 0, opI32Const, 0xe4, 0, 0x3a, 0, 0}},
+		}, nil)
+		sections, err := readWasmSections(counter)
+		Expect(err).ShouldNot(HaveOccurred())
+		var raw []rawSection
+		for _, section := range sections {
+			payload := section.Payload
+			if section.ID == wasmCustomSectionID {
+				payload = append(appendName(nil, section.Name), payload...)
+			}
+			raw = append(raw, rawSection{id: section.ID, payload: payload})
+		}
+		raw = insertSection(raw, rawSection{id: wasmStartSectionID, payload: []byte{2}})
+		started := append([]byte(nil), counter[:8]...)
+		for _, section := range raw {
+			started = append(started, section.id)
+			started = append(started, uleb(uint32(len(section.payload)))...)
+			started = append(started, section.payload...)
+		}
+
+		stub := newWazeroStub()
+		result := stub.MockInvoke("000", [][]byte{[]byte("create"), []byte("startwasm"), started})
+		Expect(result.Status).Should(Equal(status200), result.Message)
+		result = stub.MockInvoke("000", [][]byte{[]byte("execute"), []byte("startwasm"), []byte("count")})
+		Expect(result.Status).Should(Equal(status200), result.Message)
+		Expect(string(result.Payload)).Should(Equal("100"))
+	})
+	It("should reject modules exporting names used for metering", func() {
+		module := buildTestModule(nil, []testFunc{
+			{name: meteringGasExport, params: []byte{i64}, results: []byte{i64}, body: returnI64(0)},
+		}, nil)
+		_, err := meterModule(module, defaultRuntimeLimits())
+		Expect(err).Should(HaveOccurred())
+	})
+	It("should reject modules accessing the locals and globals used for metering", func() {
+		writesGas := buildTestModule(nil, []testFunc{
+			{name: "init", params: []byte{i64}, results: []byte{i64}, body: concat(
+				[]byte{opI64Const, 0x7f, wasmOpGlobalSet, meteringGasGlobal}, returnI64(0))},
+		}, nil)
+		_, err := meterModule(writesGas, defaultRuntimeLimits())
+		Expect(err).Should(HaveOccurred())
+
+		result := newWazeroStub().MockInvoke("000",
+			[][]byte{[]byte("create"), []byte("gaswasm"), writesGas})
+		Expect(result.Status).Should(Equal(status500))
+
+		readsScratch := buildTestModule(nil, []testFunc{
+			{name: "init", params: []byte{i64}, results: []byte{i64}, body: []byte{opLocalGet, 1}},
+		}, nil)
+		_, err = meterModule(readsScratch, defaultRuntimeLimits())
+		Expect(err).Should(HaveOccurred())
+	})
+})
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+
+	"github.com/perlin-network/life/compiler"
+)
+
+// DefaultGasLimit is the amount of gas a single wasm invocation may use.
+const DefaultGasLimit = 100000000
+
+// errOutOfGas is reported when an invocation exhausts its gas limit.
+var errOutOfGas = errors.New("out of gas")
+
+// lifeGasLimitExceeded is the panic raised by life when the gas limit is hit.
+const lifeGasLimitExceeded = "gas limit exceeded"
+
+// Gas costs of wasm instructions which are more expensive than the default cost of 1.
+// Costs are summed up per basic block when a module is compiled, so the gas charged for
+// an invocation only depends on the module and its inputs and is the same on every peer.
+// Changing this table changes the gas used by existing chaincodes.
+var instructionGasCosts = map[string]int64{
+	"call":          10,
+	"call_indirect": 15,
+	"memory.grow":   1000,
+	"memory.size":   2,
+	"unreachable":   0,
+	"return":        2,
+	"jmp_table":     3,
+
+	"i32.mul":   2,
+	"i32.div_s": 8,
+	"i32.div_u": 8,
+	"i32.rem_s": 8,
+	"i32.rem_u": 8,
+	"i64.mul":   3,
+	"i64.div_s": 12,
+	"i64.div_u": 12,
+	"i64.rem_s": 12,
+	"i64.rem_u": 12,
+
+	"f32.div":  10,
+	"f32.sqrt": 10,
+	"f64.div":  12,
+	"f64.sqrt": 12,
+}
+
+// Cost of every memory load and store instruction.
+const memoryAccessGasCost = 3
+
+// gasPolicy is the deterministic per opcode cost table passed to life's compiler.
+type gasPolicy struct{}
+
+func (gasPolicy) GetCost(ins compiler.Instr) int64 {
+	if cost, ok := instructionGasCosts[ins.Op]; ok {
+		return cost
+	}
+	if strings.Contains(ins.Op, ".load") || strings.Contains(ins.Op, ".store") {
+		return memoryAccessGasCost
+	}
+	return 1
+}
+
+// gasError translates life's gas limit panic into errOutOfGas.
+func gasError(err error, limit uint64) error {
+	if err.Error() == lifeGasLimitExceeded {
+		return fmt.Errorf("%s: gas limit of %d exceeded", errOutOfGas, limit)
+	}
+	return err
+}
+
+// gasUsedEvent is the chaincode event reporting the gas used by an invocation.
+const gasUsedEvent = "gasUsed"
+
+// withGasUsed reports the gas used by an invocation in the gasUsed chaincode event, the
+// response is returned unchanged.
+func withGasUsed(stub shim.ChaincodeStubInterface, response pb.Response, gasUsed uint64) pb.Response {
+	if err := stub.SetEvent(gasUsedEvent, []byte(strconv.FormatUint(gasUsed, 10))); err != nil && response.Status < shim.ERRORTHRESHOLD {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	return response
+}
+
+// Index name for wasmcc configuration stored on the ledger
+var wasmccConfigIndex = "wasmccConfig"
+
+// hostGasSchedule prices host function calls. A call costs the base cost of the host
+// function plus a cost per byte of keys passed and of values read or written. The schedule
+// is stored on the ledger so that all endorsers charge the same amount.
+type hostGasSchedule struct {
+	BaseCosts       map[string]uint64 `json:"baseCosts"`
+	DefaultBaseCost uint64            `json:"defaultBaseCost"`
+	KeyByteCost     uint64            `json:"keyByteCost"`
+	ReadByteCost    uint64            `json:"readByteCost"`
+	WriteByteCost   uint64            `json:"writeByteCost"`
+	Budget          uint64            `json:"budget"`
+}
+
+// defaultHostGasSchedule is used until an administrator stores a schedule.
+func defaultHostGasSchedule() *hostGasSchedule {
+	return &hostGasSchedule{
+		BaseCosts: map[string]uint64{
+			"__get_state":      2000,
+			"__get_state_size": 2000,
+			"__put_state":      5000,
+			"__delete_state":   3000,
+		},
+		DefaultBaseCost: 50,
+		KeyByteCost:     10,
+		ReadByteCost:    3,
+		WriteByteCost:   10,
+		Budget:          10000000,
+	}
+}
+
+// baseCost returns the cost of calling a host function before any bytes are charged.
+func (s *hostGasSchedule) baseCost(function string) uint64 {
+	if cost, ok := s.BaseCosts[function]; ok {
+		return cost
+	}
+	return s.DefaultBaseCost
+}
+
+func hostGasScheduleKey(stub shim.ChaincodeStubInterface) (string, error) {
+	return stub.CreateCompositeKey(wasmccConfigIndex, []string{"hostGasSchedule"})
+}
+
+// loadHostGasSchedule reads the host gas schedule from the ledger. Fields missing in the
+// stored schedule keep their default value.
+func loadHostGasSchedule(stub shim.ChaincodeStubInterface) (*hostGasSchedule, error) {
+	key, err := hostGasScheduleKey(stub)
+	if err != nil {
+		return nil, err
+	}
+	scheduleBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule := defaultHostGasSchedule()
+	if scheduleBytes != nil {
+		if err := json.Unmarshal(scheduleBytes, schedule); err != nil {
+			return nil, err
+		}
+	}
+	return schedule, nil
+}
+
+// chargeHostCall charges a host call against the host gas budget of the invocation. The
+// same amount is added to the gas used by the instance, so its gas limit covers host calls too.
+// Exceeding either limit aborts the invocation.
+func (r *Resolver) chargeHostCall(env hostEnv, function string, keyBytes, readBytes, writeBytes int) {
+	s := r.gasSchedule
+	if s == nil {
+		s = defaultHostGasSchedule()
+		r.gasSchedule = s
+	}
+
+	cost := s.baseCost(function) +
+		uint64(keyBytes)*s.KeyByteCost +
+		uint64(readBytes)*s.ReadByteCost +
+		uint64(writeBytes)*s.WriteByteCost
+	r.chargeHostGas(env, cost)
+}
+
+// chargeHostBytes charges bytes moved by a host call whose base cost was already charged,
+// e.g. a state value which is only known after the ledger was read.
+func (r *Resolver) chargeHostBytes(env hostEnv, readBytes, writeBytes int) {
+	r.chargeHostGas(env, uint64(readBytes)*r.gasSchedule.ReadByteCost+uint64(writeBytes)*r.gasSchedule.WriteByteCost)
+}
+
+// chargeHostKeys charges the base cost of a single key host function for every key of a
+// batch host call, batches only save the overhead of calling the host.
+func (r *Resolver) chargeHostKeys(env hostEnv, function string, keys int) {
+	r.chargeHostGas(env, uint64(keys)*r.gasSchedule.baseCost(function))
+}
+
+func (r *Resolver) chargeHostGas(env hostEnv, cost uint64) {
+	if r.hostGasUsed+cost < r.hostGasUsed || r.hostGasUsed+cost > r.gasSchedule.Budget {
+		panic(fmt.Errorf("%s: host call budget of %d exceeded", errOutOfGas, r.gasSchedule.Budget))
+	}
+	r.hostGasUsed += cost
+	env.addGas(cost)
+}
+
+// setHostGasSchedule stores a new host gas schedule given as JSON.
+func (t *WASMChaincode) setHostGasSchedule(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting host gas schedule as json")
+	}
+	if errResponse := checkAdmin(stub); errResponse != nil {
+		return *errResponse
+	}
+
+	schedule := defaultHostGasSchedule()
+	if err := json.Unmarshal([]byte(args[0]), schedule); err != nil {
+		return shim.Error(fmt.Sprintf(InvalidConfig, err.Error()))
+	}
+	if schedule.Budget == 0 {
+		return shim.Error(fmt.Sprintf(InvalidConfig, "host call budget must be positive"))
+	}
+
+	scheduleBytes, err := json.Marshal(schedule)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	key, err := hostGasScheduleKey(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	if err := stub.PutState(key, scheduleBytes); err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	return shim.Success(scheduleBytes)
+}
+
+// hostGasSchedule returns the host gas schedule in effect as JSON.
+func (t *WASMChaincode) hostGasSchedule(stub shim.ChaincodeStubInterface) pb.Response {
+	schedule, err := loadHostGasSchedule(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	scheduleBytes, err := json.Marshal(schedule)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	return shim.Success(scheduleBytes)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/perlin-network/life/compiler"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// reportedGasUsed returns the gas reported by the last invocation on the stub and drains its
+// chaincode events.
+func reportedGasUsed(stub *shim.MockStub) uint64 {
+	var event *pb.ChaincodeEvent
+	for len(stub.ChaincodeEventsChannel) > 0 {
+		event = <-stub.ChaincodeEventsChannel
+	}
+	Expect(event).ShouldNot(BeNil())
+	Expect(event.EventName).Should(Equal(gasUsedEvent))
+	gasUsed, err := strconv.ParseUint(string(event.Payload), 10, 64)
+	Expect(err).ShouldNot(HaveOccurred())
+	return gasUsed
+}
+
+var _ = Describe("Tests for wasm gas metering", func() {
+
+	status200 := int32(200)
+	status500 := int32(500)
+
+	spinningModule := buildTestModule(nil, []testFunc{
+		{name: "init", params: []byte{i64}, results: []byte{i64}, body: returnI64(0)},
+		{name: "spin", params: []byte{i64}, results: []byte{i64}, body: []byte{opLoop, 0x40, opBr, 0, opEnd, opUnreachable}},
+	}, nil)
+
+	Describe("Gas policy", func() {
+		It("should charge more for expensive instructions", func() {
+			policy := gasPolicy{}
+			Expect(policy.GetCost(compiler.Instr{Op: "i32.add"})).Should(Equal(int64(1)))
+			Expect(policy.GetCost(compiler.Instr{Op: "i64.load32_u"})).Should(Equal(int64(memoryAccessGasCost)))
+			Expect(policy.GetCost(compiler.Instr{Op: "call"})).Should(Equal(int64(10)))
+		})
+	})
+
+	Describe("Metered execution", func() {
+		stub := shim.NewMockStub("gasStub", new(WASMChaincode))
+		stub.MockInit("000", nil)
+
+		It("should report gas used by init", func() {
+			result := stub.MockInvoke("000",
+				[][]byte{[]byte("create"), []byte("spinwasm"), spinningModule})
+			Expect(result.Status).Should(Equal(status200))
+			Expect(result.Message).Should(BeEmpty())
+			Expect(reportedGasUsed(stub)).Should(BeNumerically(">", 0))
+		})
+		It("should abort an infinite loop with out of gas", func() {
+			result := stub.MockInvoke("000",
+				[][]byte{[]byte("execute"), []byte("spinwasm"), []byte("spin")})
+			Expect(result.Status).Should(Equal(status500))
+			Expect(result.Message).Should(ContainSubstring("out of gas"))
+		})
+	})
+
+	Describe("Host call pricing", func() {
+		stub := newAdminStub("hostGasStub")
+
+		It("should use the default schedule until one is stored", func() {
+			result := stub.MockInvoke("000", [][]byte{[]byte("hostGasSchedule")})
+			Expect(result.Status).Should(Equal(status200))
+			Expect(string(result.Payload)).Should(ContainSubstring(`"budget":10000000`))
+		})
+		It("should charge host calls on top of instructions", func() {
+			result := stub.MockInvoke("000",
+				[][]byte{[]byte("create"), []byte("balancewasm"), ReadAssetTransferWASM(),
+					[]byte("account1"), []byte("100"), []byte("account2"), []byte("1000")})
+			Expect(result.Status).Should(Equal(status200))
+
+			// two puts of 8 byte keys and short values dominate the cost of init
+			gasUsed := reportedGasUsed(stub)
+			Expect(gasUsed).Should(BeNumerically(">=", 10000))
+			Expect(gasUsed).Should(BeNumerically("<", 20000))
+		})
+		It("should charge parameter reads which are out of range", func() {
+			for _, field := range []string{"__get_parameter", "__get_parameter_size"} {
+				r := &Resolver{}
+				env := &meteredEnv{}
+				Expect(r.hostFunction("env", field)(env, []int64{3, 0})).Should(Equal(int64(-1)))
+				Expect(env.gas).Should(Equal(defaultHostGasSchedule().DefaultBaseCost))
+			}
+		})
+		It("should reject a schedule without budget", func() {
+			result := invokeAsAdmin(stub, []byte("setHostGasSchedule"), []byte(`{"budget":0}`))
+			Expect(result.Status).Should(Equal(status500))
+		})
+		It("should abort invocations exceeding the host call budget", func() {
+			result := invokeAsAdmin(stub, []byte("setHostGasSchedule"), []byte(`{"budget":1000}`))
+			Expect(result.Status).Should(Equal(status200))
+
+			result = stub.MockInvoke("000",
+				[][]byte{[]byte("execute"), []byte("balancewasm"), []byte("query"), []byte("account1")})
+			Expect(result.Status).Should(Equal(status500))
+			Expect(result.Message).Should(ContainSubstring("out of gas: host call budget of 1000 exceeded"))
+		})
+	})
+})
+
+// meteredEnv is a host environment without memory which records the gas charged to it.
+type meteredEnv struct {
+	gas uint64
+}
+
+func (e *meteredEnv) memory() []byte {
+	return nil
+}
+
+func (e *meteredEnv) addGas(gas uint64) {
+	e.gas += gas
+}
@@ -4,6 +4,7 @@ require (
 	github.com/Knetic/govaluate v3.0.0+incompatible // indirect
 	github.com/Shopify/sarama v1.23.1 // indirect
 	github.com/fsouza/go-dockerclient v1.4.2 // indirect
+	github.com/go-interpreter/wagon v0.6.0
 	github.com/golang/protobuf v1.3.2 // indirect
 	github.com/h2non/filetype v1.0.10
 	github.com/hashicorp/go-version v1.2.0 // indirect
@@ -16,6 +17,7 @@ require (
 	github.com/perlin-network/life v0.0.0-20191203030451-05c0e0f7eaea
 	github.com/spf13/viper v1.4.0 // indirect
 	github.com/sykesm/zap-logfmt v0.0.2 // indirect
+	github.com/tetratelabs/wazero v1.8.2
 	golang.org/x/crypto v0.0.0-20191202143827-86a70503ff7e // indirect
 	golang.org/x/net v0.0.0-20191204025024-5ee1b9f4859a // indirect
 	golang.org/x/sync v0.0.0-20190911185100-cd5d95a43a6e // indirect
@@ -0,0 +1,92 @@
+package main
+
+// hostFunctionVersions maps the host functions wasmcc exports to the version of the host ABI
+// which introduced them. Version 1 is the original set of functions, version 2 added batch
+// state access, responses and last errors.
+var hostFunctionVersions = map[string]int{
+	"__print":              1,
+	"__get_parameter":      1,
+	"__get_parameter_size": 1,
+	"__get_state":          1,
+	"__get_state_size":     1,
+	"__put_state":          1,
+	"__delete_state":       1,
+	"__return_result":      1,
+	"__get_exception_msg":  1,
+	"__get_states_size":    2,
+	"__get_states":         2,
+	"__put_states":         2,
+	"__set_response":       2,
+	"__last_error_code":    2,
+	"__last_error_size":    2,
+	"__last_error":         2,
+}
+
+// latestHostABIVersion is the version of the host ABI implemented by wasmcc.
+const latestHostABIVersion = 2
+
+// wasmImport is an entry of the import section of a module.
+type wasmImport struct {
+	Module string `json:"module"`
+	Name   string `json:"name"`
+	Kind   string `json:"kind"`
+}
+
+var importKinds = []string{"function", "table", "memory", "global"}
+
+// moduleImports returns the imports of a module.
+func moduleImports(code []byte) ([]wasmImport, error) {
+	sections, err := readWasmSections(code)
+	if err != nil {
+		return nil, err
+	}
+
+	var imports []wasmImport
+	for _, section := range sections {
+		if section.ID != wasmImportSectionID {
+			continue
+		}
+		r := &wasmReader{buf: section.Payload}
+		count := int(r.u32())
+		for i := 0; i < count && r.err == nil; i++ {
+			entry := wasmImport{Module: r.name(), Name: r.name()}
+			kind := r.byte()
+			switch kind {
+			case 0: // function
+				r.u32()
+			case 1: // table
+				r.byte()
+				r.limits()
+			case 2: // memory
+				r.limits()
+			case 3: // global
+				r.byte()
+				r.byte()
+			default:
+				return nil, errMalformedWasm
+			}
+			entry.Kind = importKinds[kind]
+			imports = append(imports, entry)
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+	return imports, nil
+}
+
+// hostABIVersion returns the host ABI version a module targets, the newest version of the
+// host functions it imports. Modules importing no host functions target version 1.
+func hostABIVersion(code []byte) (int, error) {
+	imports, err := moduleImports(code)
+	if err != nil {
+		return 0, err
+	}
+	version := 1
+	for _, entry := range imports {
+		if entry.Module == "env" && entry.Kind == "function" && hostFunctionVersions[entry.Name] > version {
+			version = hostFunctionVersions[entry.Name]
+		}
+	}
+	return version, nil
+}
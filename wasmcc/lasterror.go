@@ -0,0 +1,26 @@
+package main
+
+// Codes of the last error of a host function, which wasm chaincodes read with
+// __last_error_code to branch on failures of host functions returning -1. The code is 0 until
+// a host function fails.
+const (
+	errCodeNotFound        = 1
+	errCodeOutOfBounds     = 2
+	errCodeLedgerError     = 3
+	errCodeInvalidArgument = 4
+)
+
+// setLastError records why a host function failed. The error is kept until another host
+// function fails, successful calls do not clear it.
+func (r *Resolver) setLastError(code int64, msg string) {
+	r.errCode = code
+	r.errMsg = []byte(msg)
+}
+
+// batchErrorCode returns the code of an error of a batch host function.
+func batchErrorCode(err error) int64 {
+	if err == errMalformedBatch {
+		return errCodeInvalidArgument
+	}
+	return errCodeLedgerError
+}
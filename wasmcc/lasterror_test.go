@@ -0,0 +1,75 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tests for the last error of host functions", func() {
+
+	status200 := int32(200)
+
+	const opI32WrapI64 = 0xa7
+	imports := []testImport{
+		{field: "__get_state", params: []byte{i32, i32, i32}, results: []byte{i64}},
+		{field: "__get_parameter_size", params: []byte{i32}, results: []byte{i64}},
+		{field: "__last_error_code", results: []byte{i64}},
+		{field: "__last_error_size", results: []byte{i64}},
+		{field: "__last_error", params: []byte{i32}, results: []byte{i64}},
+		{field: "__return_result", params: []byte{i32, i32}, results: []byte{i64}},
+		{field: "__get_exception_msg", params: []byte{i32, i32}, results: []byte{i64}},
+	}
+	const getState, getParameterSize, lastErrorCode, lastErrorSize, lastError, returnResult, getExceptionMsg = 0, 1, 2, 3, 4, 5, 6
+
+	getMissing := []byte{opI32Const, 0, opI32Const, 1, opI32Const, 32, opCall, getState, opDrop}
+	lastErrorModule := buildTestModule(imports, []testFunc{
+		{name: "init", params: []byte{i64}, results: []byte{i64}, body: returnI64(0)},
+		{name: "none", params: []byte{i64}, results: []byte{i64}, body: []byte{opCall, lastErrorCode}},
+		{name: "notfound", params: []byte{i64}, results: []byte{i64}, body: concat(getMissing, []byte{opCall, lastErrorCode})},
+		{name: "outofbounds", params: []byte{i64}, results: []byte{i64}, body: []byte{opI32Const, 5, opCall, getParameterSize, opDrop, opCall, lastErrorCode}},
+		{name: "size", params: []byte{i64}, results: []byte{i64}, body: concat(getMissing, []byte{opCall, lastErrorSize})},
+		{name: "message", params: []byte{i64}, results: []byte{i64}, body: concat(getMissing,
+			[]byte{opI32Const, 32, opI32Const, 32, opCall, lastError, opI32WrapI64, opCall, returnResult, opDrop}, returnI64(0))},
+		{name: "exception", params: []byte{i64}, results: []byte{i64}, body: []byte{
+			opI32Const, 0, opI32Const, 1, opCall, getExceptionMsg, opDrop, opCall, lastErrorSize}},
+		{name: "fail", params: []byte{i64}, results: []byte{i64}, body: concat(
+			[]byte{opI32Const, 0, opI32Const, 1, opCall, getExceptionMsg, opDrop}, returnI64(-1))},
+	}, []byte("k"))
+
+	stub := shim.NewMockStub("lastErrorStub", new(WASMChaincode))
+	stub.MockInit("000", nil)
+
+	execute := func(function string) string {
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("execute"), []byte("lasterrorwasm"), []byte(function)})
+		Expect(result.Status).Should(Equal(status200), result.Message)
+		return string(result.Payload)
+	}
+
+	It("should be created", func() {
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("create"), []byte("lasterrorwasm"), lastErrorModule})
+		Expect(result.Status).Should(Equal(status200))
+	})
+	It("should have no error before a host function fails", func() {
+		Expect(execute("none")).Should(Equal("0"))
+	})
+	It("should return the code of the last error", func() {
+		Expect(execute("notfound")).Should(Equal("1"))
+		Expect(execute("outofbounds")).Should(Equal("2"))
+	})
+	It("should return the message of the last error", func() {
+		Expect(execute("size")).Should(Equal("22"))
+		Expect(execute("message")).Should(Equal(NoResultForGetState))
+	})
+	It("should keep the exception message of the chaincode apart from the last error", func() {
+		Expect(execute("exception")).Should(Equal("0"))
+
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("execute"), []byte("lasterrorwasm"), []byte("fail")})
+		Expect(result.Status).Should(Equal(int32(500)))
+		Expect(result.Message).Should(Equal("k"))
+	})
+})
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// defaultPurgeBatchSize is the number of state keys of a deleted chaincode purged by a
+// transaction unless a batch size is passed. Large states take several transactions, a
+// single one would exceed the limits of the peer.
+const defaultPurgeBatchSize = 1000
+
+// purgeResult tells how many state keys a transaction purged and whether any are left.
+type purgeResult struct {
+	PurgedKeys int  `json:"purgedKeys"`
+	Complete   bool `json:"complete"`
+}
+
+// pause makes execute of a chaincode fail until it is resumed. Its code, versions, tags and
+// state are kept. Receives chaincode name.
+func (t *WASMChaincode) pause(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return t.setPaused(stub, args, true)
+}
+
+// resume lets a paused chaincode be executed again. Receives chaincode name.
+func (t *WASMChaincode) resume(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return t.setPaused(stub, args, false)
+}
+
+func (t *WASMChaincode) setPaused(stub shim.ChaincodeStubInterface, args []string, paused bool) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode name")
+	}
+	chaincodeName := args[0]
+
+	code, errResponse := loadChaincodeCode(stub, chaincodeRef{name: chaincodeName})
+	if errResponse != nil {
+		return *errResponse
+	}
+	if errResponse := checkOwnerOrAdmin(stub, chaincodeName); errResponse != nil {
+		return *errResponse
+	}
+	metadata, err := loadMetadata(stub, chaincodeName, code)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	metadata.Paused = paused
+	if err := storeMetadata(stub, chaincodeName, metadata); err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+
+	logger.Infof("Chaincode %s paused: %t", chaincodeName, paused)
+	metadataBytes, err := json.Marshal(metadata)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	return shim.Success(metadataBytes)
+}
+
+// delete removes a chaincode: its name, versions and tags no longer point to modules, which
+// collectModules then deletes, and its metadata, symbols, runtime limits, owner and pending
+// proposal are deleted. Receives chaincode name and optionally "true" to purge the first batch of its
+// state, the rest is purged by purgeState.
+func (t *WASMChaincode) delete(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Infof("Delete function")
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode name and optionally whether to purge its state")
+	}
+	chaincodeName := args[0]
+	purge := false
+	if len(args) == 2 {
+		var err error
+		if purge, err = strconv.ParseBool(args[1]); err != nil {
+			return shim.Error(fmt.Sprintf(InvalidConfig, "invalid purge flag "+args[1]))
+		}
+	}
+
+	if _, errResponse := loadChaincodeCode(stub, chaincodeRef{name: chaincodeName}); errResponse != nil {
+		return *errResponse
+	}
+	if errResponse := checkOwnerOrAdmin(stub, chaincodeName); errResponse != nil {
+		return *errResponse
+	}
+	if purge {
+		if errResponse := checkPurgeable(stub, chaincodeName); errResponse != nil {
+			return *errResponse
+		}
+	}
+
+	store := newModuleStore(stub)
+	ledgerChaincodeKey, err := stub.CreateCompositeKey(chaincodeStoreIndex, []string{chaincodeName})
+	if err == nil {
+		err = store.unpoint(ledgerChaincodeKey)
+	}
+	if err == nil {
+		err = unpointAll(store, chaincodeHistoryIndex, chaincodeName)
+	}
+	if err == nil {
+		err = unpointAll(store, chaincodeTagIndex, chaincodeName)
+	}
+	if err == nil {
+		err = store.flush()
+	}
+	if err == nil {
+		err = deleteRegistryEntries(stub, chaincodeName)
+	}
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	logger.Infof("Deleted wasm chaincode: " + chaincodeName)
+
+	if !purge {
+		return shim.Success(nil)
+	}
+	return purgeStateBatch(stub, chaincodeName, defaultPurgeBatchSize)
+}
+
+// unpointAll unpoints every key of an index for a chaincode, its versions or its tags.
+func unpointAll(store *moduleStore, index, chaincodeName string) error {
+	iterator, err := store.stub.GetStateByPartialCompositeKey(index, []string{chaincodeName})
+	if err != nil {
+		return err
+	}
+	defer iterator.Close()
+
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return err
+		}
+		if err := store.unpoint(entry.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteRegistryEntries deletes the metadata, symbol table, runtime limits, owner and pending
+// proposal with its approvals of a chaincode.
+func deleteRegistryEntries(stub shim.ChaincodeStubInterface, chaincodeName string) error {
+	for _, index := range []string{chaincodeMetadataIndex, chaincodeSymbolsIndex, chaincodeLimitsIndex, chaincodeOwnerIndex, chaincodeProposalIndex} {
+		key, err := stub.CreateCompositeKey(index, []string{chaincodeName})
+		if err != nil {
+			return err
+		}
+		if err := stub.DelState(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeState deletes the next batch of the state left by a deleted chaincode, and tells
+// whether the state is purged completely. Receives chaincode name and optionally the
+// maximum number of keys to delete.
+func (t *WASMChaincode) purgeState(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode name and optionally batch size")
+	}
+	chaincodeName := args[0]
+	if chaincodeName == "" {
+		return shim.Error("Incorrect arguments. Chaincode name must not be empty")
+	}
+	batchSize := defaultPurgeBatchSize
+	if len(args) == 2 {
+		size, err := strconv.Atoi(args[1])
+		if err != nil || size <= 0 {
+			return shim.Error(fmt.Sprintf(InvalidConfig, "invalid batch size "+args[1]))
+		}
+		batchSize = size
+	}
+	if errResponse := checkAdmin(stub); errResponse != nil {
+		return *errResponse
+	}
+
+	ledgerChaincodeKey, err := stub.CreateCompositeKey(chaincodeStoreIndex, []string{chaincodeName})
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	installed, err := stub.GetState(ledgerChaincodeKey)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	if installed != nil {
+		return shim.Error("{\"Error\":\"Chaincode " + chaincodeName + " is installed, delete it before purging its state\"}")
+	}
+	if errResponse := checkPurgeable(stub, chaincodeName); errResponse != nil {
+		return *errResponse
+	}
+	return purgeStateBatch(stub, chaincodeName, batchSize)
+}
+
+// checkPurgeable returns an error response if the state of a chaincode cannot be told apart
+// from the state of an installed chaincode: the key b_key of a chaincode a is the key key of
+// a chaincode a_b. The state of a_b can be purged once a is deleted.
+func checkPurgeable(stub shim.ChaincodeStubInterface, chaincodeName string) *pb.Response {
+	enclosing, err := installedWhere(stub, func(name string) bool {
+		return strings.HasPrefix(chaincodeName, name+"_")
+	})
+	if err != nil {
+		response := shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+		return &response
+	}
+	if len(enclosing) > 0 {
+		response := shim.Error("{\"Error\":\"State of " + chaincodeName + " cannot be told apart from the state of installed chaincode " + enclosing[0] + "\"}")
+		return &response
+	}
+	return nil
+}
+
+// purgeStateBatch deletes up to batchSize keys of the state of a chaincode, the keys with
+// the prefix name_. Keys of installed chaincodes named with that prefix, like name_v2, are
+// left alone.
+func purgeStateBatch(stub shim.ChaincodeStubInterface, chaincodeName string, batchSize int) pb.Response {
+	prefix := chaincodeName + "_"
+	others, err := installedWhere(stub, func(name string) bool {
+		return strings.HasPrefix(name, prefix)
+	})
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+
+	// '`' follows '_', the range holds exactly the keys with the prefix
+	iterator, err := stub.GetStateByRange(prefix, chaincodeName+"`")
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	defer iterator.Close()
+
+	result := purgeResult{Complete: true}
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+		}
+		if ownedByOther(entry.Key, others) {
+			continue
+		}
+		if result.PurgedKeys == batchSize {
+			result.Complete = false
+			break
+		}
+		if err := stub.DelState(entry.Key); err != nil {
+			return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+		}
+		result.PurgedKeys++
+	}
+
+	logger.Infof("Purged %d state keys of %s, complete: %t", result.PurgedKeys, chaincodeName, result.Complete)
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	return shim.Success(resultBytes)
+}
+
+// installedWhere returns the names of installed chaincodes which match.
+func installedWhere(stub shim.ChaincodeStubInterface, match func(name string) bool) ([]string, error) {
+	iterator, err := stub.GetStateByPartialCompositeKey(chaincodeStoreIndex, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+
+	var names []string
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(entry.Key)
+		if err != nil {
+			return nil, err
+		}
+		if match(keyParts[0]) {
+			names = append(names, keyParts[0])
+		}
+	}
+	return names, nil
+}
+
+func ownedByOther(key string, others []string) bool {
+	for _, name := range others {
+		if strings.HasPrefix(key, name+"_") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tests for pausing and deleting chaincodes", func() {
+
+	status200 := int32(200)
+	status500 := int32(500)
+
+	module := buildTestModule(nil, []testFunc{
+		{name: "init", params: []byte{i64}, results: []byte{i64}, body: returnI64(0)},
+		{name: "version", params: []byte{i64}, results: []byte{i64}, body: returnI64(1)},
+	}, nil)
+
+	stub := newAdminStub("lifecycleStub")
+
+	invoke := func(args ...string) (int32, string, string) {
+		rawArgs := make([][]byte, len(args))
+		for i, arg := range args {
+			rawArgs[i] = []byte(arg)
+		}
+		result := invokeAsAdmin(stub, rawArgs...)
+		return result.Status, result.Message, string(result.Payload)
+	}
+	create := func(name string) {
+		result := stub.MockInvoke("000", [][]byte{[]byte("create"), []byte(name), module})
+		Expect(result.Status).Should(Equal(status200), result.Message)
+	}
+	putStates := func(prefix string, count int) {
+		stub.MockTransactionStart("states")
+		for i := 0; i < count; i++ {
+			Expect(stub.PutState(fmt.Sprintf("%s_key%d", prefix, i), []byte("value"))).Should(Succeed())
+		}
+		stub.MockTransactionEnd("states")
+	}
+	// keysOf returns the number of keys on the ledger with a prefix
+	keysOf := func(prefix string) int {
+		count := 0
+		for key := range stub.State {
+			if strings.HasPrefix(key, prefix) {
+				count++
+			}
+		}
+		return count
+	}
+
+	It("should reject execute of paused chaincodes", func() {
+		create("paused")
+		status, _, payload := invoke("pause", "paused")
+		Expect(status).Should(Equal(status200))
+		Expect(payload).Should(ContainSubstring(`"paused":true`))
+
+		status, message, _ := invoke("execute", "paused", "version")
+		Expect(status).Should(Equal(status500))
+		Expect(message).Should(Equal(fmt.Sprintf(ChaincodePaused, "paused")))
+		status, _, _ = invoke("execute", "paused@1", "version")
+		Expect(status).Should(Equal(status500))
+	})
+	It("should keep chaincodes paused across upgrades", func() {
+		result := stub.MockInvoke("000", [][]byte{[]byte("upgrade"), []byte("paused"), module})
+		Expect(result.Status).Should(Equal(status200))
+		Expect(string(result.Payload)).Should(ContainSubstring(`"paused":true`))
+	})
+	It("should execute resumed chaincodes", func() {
+		status, _, payload := invoke("resume", "paused")
+		Expect(status).Should(Equal(status200))
+		Expect(payload).ShouldNot(ContainSubstring("paused"))
+
+		status, _, payload = invoke("execute", "paused", "version")
+		Expect(status).Should(Equal(status200))
+		Expect(payload).Should(Equal("1"))
+	})
+	It("should delete chaincodes and keep their state", func() {
+		create("kept")
+		putStates("kept", 3)
+		status, _, _ := invoke("setTag", "kept", "stable", "1")
+		Expect(status).Should(Equal(status200))
+		// an approved upgrade which must not be committed once the chaincode is deleted
+		stub.MockTransactionStart("proposal")
+		_, err := storeProposal(stub, "kept", &chaincodeProposal{CodeHash: codeHash(module), Approvals: []string{"Org1MSP"}})
+		Expect(err).ShouldNot(HaveOccurred())
+		stub.MockTransactionEnd("proposal")
+
+		status, _, _ = invoke("delete", "kept")
+		Expect(status).Should(Equal(status200))
+		Expect(keysOf("kept_")).Should(Equal(3))
+		for _, index := range []string{chaincodeStoreIndex, chaincodeMetadataIndex, chaincodeHistoryIndex, chaincodeTagIndex, chaincodeSymbolsIndex, chaincodeProposalIndex} {
+			Expect(keysOf("\x00"+index+"\x00kept\x00")).Should(Equal(0), index)
+		}
+
+		status, message, _ := invoke("execute", "kept", "version")
+		Expect(status).Should(Equal(status500))
+		Expect(message).Should(ContainSubstring("No Chaincode for kept"))
+		status, _, _ = invoke("delete", "kept")
+		Expect(status).Should(Equal(status500))
+	})
+	It("should release the modules of deleted chaincodes", func() {
+		status, _, _ := invoke("delete", "paused")
+		Expect(status).Should(Equal(status200))
+
+		status, _, payload := invoke("collectModules")
+		Expect(status).Should(Equal(status200))
+		Expect(payload).Should(Equal(`["` + codeHash(module) + `"]`))
+	})
+	It("should purge the state of deleted chaincodes in batches", func() {
+		create("purged")
+		create("purged_v2")
+		putStates("purged", 5)
+		putStates("purged_v2", 2)
+
+		status, message, _ := invoke("purgeState", "purged")
+		Expect(status).Should(Equal(status500))
+		Expect(message).Should(ContainSubstring("is installed"))
+
+		status, _, payload := invoke("delete", "purged", "true")
+		Expect(status).Should(Equal(status200))
+		Expect(payload).Should(Equal(`{"purgedKeys":5,"complete":true}`))
+		Expect(keysOf("purged_key")).Should(Equal(0))
+		Expect(keysOf("purged_v2_")).Should(Equal(2))
+
+		status, _, _ = invoke("delete", "purged_v2")
+		Expect(status).Should(Equal(status200))
+		status, _, payload = invoke("purgeState", "purged_v2", "1")
+		Expect(status).Should(Equal(status200))
+		Expect(payload).Should(Equal(`{"purgedKeys":1,"complete":false}`))
+		status, _, payload = invoke("purgeState", "purged_v2", "1")
+		Expect(status).Should(Equal(status200))
+		Expect(payload).Should(Equal(`{"purgedKeys":1,"complete":true}`))
+		Expect(keysOf("purged_v2_")).Should(Equal(0))
+	})
+	It("should not purge state which is the state of another installed chaincode", func() {
+		create("a")
+		putStates("a_b", 2)
+		create("a_b")
+
+		status, message, _ := invoke("delete", "a_b", "true")
+		Expect(status).Should(Equal(status500))
+		Expect(message).Should(ContainSubstring("installed chaincode a"))
+		Expect(keysOf("a_b_key")).Should(Equal(2))
+
+		status, _, _ = invoke("delete", "a_b")
+		Expect(status).Should(Equal(status200))
+		status, message, _ = invoke("purgeState", "a_b")
+		Expect(status).Should(Equal(status500))
+		Expect(message).Should(ContainSubstring("installed chaincode a"))
+		Expect(keysOf("a_b_key")).Should(Equal(2))
+	})
+})
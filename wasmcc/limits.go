@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+
+	"github.com/perlin-network/life/exec"
+)
+
+// Index name for runtime limits of individual wasm chaincodes
+var chaincodeLimitsIndex = "chaincodeLimits"
+
+// Memory pages and table size given to modules which import them instead of declaring
+// their own, if the limits allow that many.
+const (
+	importedMemoryPages = 128
+	importedTableSize   = 65536
+)
+
+// runtimeLimits bound the resources a single wasm invocation may use. Limits of a chaincode
+// are stored as overrides of the channel default, zero values inherit the default.
+type runtimeLimits struct {
+	MaxMemoryPages    int    `json:"maxMemoryPages,omitempty"`
+	MaxTableSize      int    `json:"maxTableSize,omitempty"`
+	MaxCallStackDepth int    `json:"maxCallStackDepth,omitempty"`
+	MaxValueSlots     int    `json:"maxValueSlots,omitempty"`
+	GasLimit          uint64 `json:"gasLimit,omitempty"`
+	MaxArgsSize       int    `json:"maxArgsSize,omitempty"`
+}
+
+// defaultRuntimeLimits apply until an administrator stores a channel default.
+func defaultRuntimeLimits() runtimeLimits {
+	return runtimeLimits{
+		MaxMemoryPages:    512,
+		MaxTableSize:      importedTableSize,
+		MaxCallStackDepth: exec.DefaultCallStackSize,
+		MaxValueSlots:     1048576,
+		GasLimit:          DefaultGasLimit,
+		MaxArgsSize:       1048576,
+	}
+}
+
+// override returns the limits with every non zero field of o replacing the current value.
+func (l runtimeLimits) override(o runtimeLimits) runtimeLimits {
+	if o.MaxMemoryPages != 0 {
+		l.MaxMemoryPages = o.MaxMemoryPages
+	}
+	if o.MaxTableSize != 0 {
+		l.MaxTableSize = o.MaxTableSize
+	}
+	if o.MaxCallStackDepth != 0 {
+		l.MaxCallStackDepth = o.MaxCallStackDepth
+	}
+	if o.MaxValueSlots != 0 {
+		l.MaxValueSlots = o.MaxValueSlots
+	}
+	if o.GasLimit != 0 {
+		l.GasLimit = o.GasLimit
+	}
+	if o.MaxArgsSize != 0 {
+		l.MaxArgsSize = o.MaxArgsSize
+	}
+	return l
+}
+
+func (l runtimeLimits) validate() error {
+	if l.MaxMemoryPages < 0 || l.MaxTableSize < 0 || l.MaxCallStackDepth < 0 || l.MaxValueSlots < 0 || l.MaxArgsSize < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	if l.MaxMemoryPages > 65536 {
+		return fmt.Errorf("maxMemoryPages must not exceed 65536")
+	}
+	return nil
+}
+
+// vmConfig translates the limits into the configuration of a life vm.
+func (l runtimeLimits) vmConfig() exec.VMConfig {
+	memoryPages := importedMemoryPages
+	if l.MaxMemoryPages < memoryPages {
+		memoryPages = l.MaxMemoryPages
+	}
+	tableSize := importedTableSize
+	if l.MaxTableSize < tableSize {
+		tableSize = l.MaxTableSize
+	}
+
+	return exec.VMConfig{
+		DefaultMemoryPages:   memoryPages,
+		DefaultTableSize:     tableSize,
+		MaxMemoryPages:       l.MaxMemoryPages,
+		MaxTableSize:         l.MaxTableSize,
+		MaxCallStackDepth:    l.MaxCallStackDepth,
+		MaxValueSlots:        l.MaxValueSlots,
+		GasLimit:             l.GasLimit,
+		DisableFloatingPoint: false,
+	}
+}
+
+// checkArgsSize rejects transaction parameters which are larger than allowed in total.
+func (l runtimeLimits) checkArgsSize(args [][]byte) error {
+	size := 0
+	for _, arg := range args {
+		size += len(arg)
+	}
+	if size > l.MaxArgsSize {
+		return fmt.Errorf(ArgsTooLarge, size, l.MaxArgsSize)
+	}
+	return nil
+}
+
+func runtimeLimitsKey(stub shim.ChaincodeStubInterface, chaincodeName string) (string, error) {
+	if chaincodeName == "" {
+		return stub.CreateCompositeKey(wasmccConfigIndex, []string{"runtimeLimits"})
+	}
+	return stub.CreateCompositeKey(chaincodeLimitsIndex, []string{chaincodeName})
+}
+
+// readRuntimeLimits reads the limits stored for a chaincode, or the channel default when
+// chaincodeName is empty. Found is false if nothing was stored.
+func readRuntimeLimits(stub shim.ChaincodeStubInterface, chaincodeName string) (limits runtimeLimits, found bool, err error) {
+	key, err := runtimeLimitsKey(stub, chaincodeName)
+	if err != nil {
+		return limits, false, err
+	}
+	limitsBytes, err := stub.GetState(key)
+	if err != nil || limitsBytes == nil {
+		return limits, false, err
+	}
+	err = json.Unmarshal(limitsBytes, &limits)
+	return limits, err == nil, err
+}
+
+// loadRuntimeLimits returns the limits in effect for a chaincode: the built in defaults,
+// overridden by the channel default, overridden by the limits of the chaincode.
+func loadRuntimeLimits(stub shim.ChaincodeStubInterface, chaincodeName string) (runtimeLimits, error) {
+	limits := defaultRuntimeLimits()
+
+	channelLimits, _, err := readRuntimeLimits(stub, "")
+	if err != nil {
+		return limits, err
+	}
+	limits = limits.override(channelLimits)
+
+	chaincodeLimits, _, err := readRuntimeLimits(stub, chaincodeName)
+	if err != nil {
+		return limits, err
+	}
+	return limits.override(chaincodeLimits), nil
+}
+
+// setRuntimeLimits stores the channel default limits, given as json, or the limits of a
+// single chaincode when its name is passed first. Only admins may set limits.
+func (t *WASMChaincode) setRuntimeLimits(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting optional chaincode name and limits as json")
+	}
+
+	chaincodeName := ""
+	if len(args) == 2 {
+		chaincodeName = args[0]
+		if chaincodeName == "" {
+			return shim.Error("Incorrect arguments. Chaincode name must not be empty")
+		}
+	}
+	// limits bound the resources of every peer, owners may not raise them for their chaincodes
+	if errResponse := checkAdmin(stub); errResponse != nil {
+		return *errResponse
+	}
+
+	var limits runtimeLimits
+	if err := json.Unmarshal([]byte(args[len(args)-1]), &limits); err != nil {
+		return shim.Error(fmt.Sprintf(InvalidConfig, err.Error()))
+	}
+	if err := limits.validate(); err != nil {
+		return shim.Error(fmt.Sprintf(InvalidConfig, err.Error()))
+	}
+
+	limitsBytes, err := json.Marshal(limits)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	if chaincodeName == "" {
+		// the channel default is part of the channel configuration
+		config, err := loadChannelConfig(stub)
+		if err == nil {
+			config.RuntimeLimits = &limits
+			err = storeChannelConfig(stub, config)
+		}
+		if err != nil {
+			return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+		}
+	} else {
+		key, err := runtimeLimitsKey(stub, chaincodeName)
+		if err != nil {
+			return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+		}
+		if err := stub.PutState(key, limitsBytes); err != nil {
+			return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+		}
+	}
+
+	logger.Infof("Runtime limits of %q set to %s", chaincodeName, limitsBytes)
+	return shim.Success(limitsBytes)
+}
+
+// runtimeLimits returns the limits in effect for a chaincode as json, or the channel
+// default when no chaincode name is passed.
+func (t *WASMChaincode) runtimeLimits(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) > 1 {
+		return shim.Error("Incorrect number of arguments. Expecting optional chaincode name")
+	}
+
+	chaincodeName := ""
+	if len(args) == 1 {
+		chaincodeName = args[0]
+	}
+
+	limits, err := loadRuntimeLimits(stub, chaincodeName)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	limitsBytes, err := json.Marshal(limits)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	return shim.Success(limitsBytes)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tests for wasm runtime limits", func() {
+
+	status200 := int32(200)
+	status500 := int32(500)
+
+	const opMemoryGrow, opI64ExtendI32S = 0x40, 0xac
+
+	limitedModule := buildTestModule(nil, []testFunc{
+		{name: "init", params: []byte{i64}, results: []byte{i64}, body: returnI64(0)},
+		{name: "grow", params: []byte{i64}, results: []byte{i64}, body: []byte{opI32Const, 4, opMemoryGrow, 0, opI64ExtendI32S}},
+		{name: "recurse", params: []byte{i64}, results: []byte{i64}, body: []byte{opLocalGet, 0, opCall, 2}},
+		{name: "spin", params: []byte{i64}, results: []byte{i64}, body: []byte{opLoop, 0x40, opBr, 0, opEnd, opUnreachable}},
+	}, nil)
+
+	Describe("Merging limits", func() {
+		It("should only override limits which are set", func() {
+			limits := defaultRuntimeLimits().override(runtimeLimits{GasLimit: 1000})
+			Expect(limits.GasLimit).Should(Equal(uint64(1000)))
+			Expect(limits.MaxMemoryPages).Should(Equal(defaultRuntimeLimits().MaxMemoryPages))
+		})
+		It("should not give imported memory more pages than allowed", func() {
+			config := runtimeLimits{MaxMemoryPages: 2, MaxTableSize: 10}.vmConfig()
+			Expect(config.DefaultMemoryPages).Should(Equal(2))
+			Expect(config.DefaultTableSize).Should(Equal(10))
+		})
+	})
+
+	Describe("Limited wasm chaincode", func() {
+		stub := newAdminStub("limitsStub")
+
+		It("should be created", func() {
+			result := stub.MockInvoke("000",
+				[][]byte{[]byte("create"), []byte("limitedwasm"), limitedModule})
+			Expect(result.Status).Should(Equal(status200))
+		})
+		It("should grow memory within the default limits", func() {
+			result := stub.MockInvoke("000",
+				[][]byte{[]byte("execute"), []byte("limitedwasm"), []byte("grow")})
+			Expect(result.Status).Should(Equal(status200))
+			Expect(string(result.Payload)).Should(Equal("1"))
+		})
+		It("should reject invalid limits", func() {
+			result := invokeAsAdmin(stub, []byte("setRuntimeLimits"), []byte("limitedwasm"), []byte(`{"maxMemoryPages":-1}`))
+			Expect(result.Status).Should(Equal(status500))
+		})
+		It("should apply limits of the chaincode", func() {
+			result := invokeAsAdmin(stub, []byte("setRuntimeLimits"), []byte("limitedwasm"),
+				[]byte(`{"maxMemoryPages":2,"maxCallStackDepth":16,"gasLimit":5000,"maxArgsSize":8}`))
+			Expect(result.Status).Should(Equal(status200))
+
+			result = stub.MockInvoke("000",
+				[][]byte{[]byte("execute"), []byte("limitedwasm"), []byte("grow")})
+			Expect(result.Status).Should(Equal(status500))
+
+			result = stub.MockInvoke("000",
+				[][]byte{[]byte("execute"), []byte("limitedwasm"), []byte("recurse")})
+			Expect(result.Status).Should(Equal(status500))
+			Expect(result.Message).Should(ContainSubstring("max call stack depth exceeded"))
+
+			result = stub.MockInvoke("000",
+				[][]byte{[]byte("execute"), []byte("limitedwasm"), []byte("spin")})
+			Expect(result.Status).Should(Equal(status500))
+			Expect(result.Message).Should(ContainSubstring("gas limit of 5000 exceeded"))
+
+			result = stub.MockInvoke("000",
+				[][]byte{[]byte("execute"), []byte("limitedwasm"), []byte("grow"), []byte("123456789")})
+			Expect(result.Status).Should(Equal(status500))
+			Expect(result.Message).Should(ContainSubstring("\"code\":406"))
+		})
+		It("should report the limits in effect", func() {
+			result := invokeAsAdmin(stub, []byte("setRuntimeLimits"), []byte(`{"maxArgsSize":4}`))
+			Expect(result.Status).Should(Equal(status200))
+
+			result = stub.MockInvoke("000",
+				[][]byte{[]byte("runtimeLimits"), []byte("limitedwasm")})
+			Expect(result.Status).Should(Equal(status200))
+			Expect(string(result.Payload)).Should(ContainSubstring(`"gasLimit":5000,"maxArgsSize":8`))
+
+			result = stub.MockInvoke("000", [][]byte{[]byte("runtimeLimits")})
+			Expect(result.Status).Should(Equal(status200))
+			Expect(string(result.Payload)).Should(ContainSubstring(`"maxArgsSize":4`))
+		})
+	})
+})
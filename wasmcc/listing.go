@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Number of chaincodes listed by installedChaincodes unless a page size is passed, and the
+// most it lists at once
+const (
+	defaultListPageSize = 100
+	maxListPageSize     = 1000
+)
+
+// chaincodeInfo describes an installed chaincode in the listing of installedChaincodes.
+type chaincodeInfo struct {
+	Name        string   `json:"name"`
+	Version     uint64   `json:"version"`
+	CodeHash    string   `json:"codeHash"`
+	Size        int      `json:"size"`
+	CreatorMSP  string   `json:"creatorMsp,omitempty"`
+	CreateTxID  string   `json:"createTxId,omitempty"`
+	CreatedAt   string   `json:"createdAt,omitempty"`
+	UpgradeTxID string   `json:"upgradeTxId,omitempty"`
+	UpgradedAt  string   `json:"upgradedAt,omitempty"`
+	Status      string   `json:"status"`
+	Functions   []string `json:"functions"`
+}
+
+// chaincodeList is a page of installed chaincodes. Bookmark is passed to installedChaincodes
+// to list the next page, it is empty on the last page.
+type chaincodeList struct {
+	Chaincodes []chaincodeInfo `json:"chaincodes"`
+	Bookmark   string          `json:"bookmark,omitempty"`
+}
+
+// installedChaincodes lists installed chaincodes as json, ordered by name. Receives an
+// optional name prefix, page size and the bookmark of the previous page. Pages are read with
+// a paginated query, so the listing is a query and cannot be part of a transaction writing
+// state.
+func (t *WASMChaincode) installedChaincodes(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) > 3 {
+		return shim.Error("Incorrect number of arguments. Expecting optional name prefix, page size and bookmark")
+	}
+	prefix, bookmark := "", ""
+	pageSize := defaultListPageSize
+	if len(args) > 0 {
+		prefix = args[0]
+	}
+	if len(args) > 1 && args[1] != "" {
+		size, err := strconv.Atoi(args[1])
+		if err != nil || size <= 0 || size > maxListPageSize {
+			return shim.Error(fmt.Sprintf(InvalidConfig, fmt.Sprintf("invalid page size %s, expecting 1 to %d", args[1], maxListPageSize)))
+		}
+		pageSize = size
+	}
+	if len(args) > 2 {
+		bookmark = args[2]
+	}
+
+	// Names with the prefix have adjacent keys, the page of the ledger starts at the first of
+	// them or at the bookmark, and holds two chaincodes more than listed, the one of the
+	// bookmark, listed before, and the one telling whether there is a next page
+	start := prefix
+	if bookmark > prefix {
+		start = bookmark
+	}
+	startKey, err := stub.CreateCompositeKey(chaincodeStoreIndex, []string{start})
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	iterator, _, err := stub.GetStateByPartialCompositeKeyWithPagination(chaincodeStoreIndex, []string{}, int32(pageSize+2), startKey)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	defer iterator.Close()
+
+	store := newModuleStore(stub)
+	list := chaincodeList{Chaincodes: []chaincodeInfo{}}
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+		}
+		_, keyParts, err := stub.SplitCompositeKey(entry.Key)
+		if err != nil {
+			return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+		}
+		name := keyParts[0]
+		if name == bookmark {
+			continue
+		}
+		if !strings.HasPrefix(name, prefix) {
+			break
+		}
+		if len(list.Chaincodes) == pageSize {
+			list.Bookmark = list.Chaincodes[pageSize-1].Name
+			break
+		}
+
+		info, err := describeInstalled(stub, store, name, entry.Key)
+		if err != nil {
+			return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+		}
+		list.Chaincodes = append(list.Chaincodes, *info)
+	}
+
+	listBytes, err := json.Marshal(list)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	logger.Infof("Listed %d wasm chaincodes", len(list.Chaincodes))
+	return shim.Success(listBytes)
+}
+
+// describeInstalled collects the listing entry of an installed chaincode from its metadata.
+// Only the modules of chaincodes stored before their size and exported functions were
+// recorded are read.
+func describeInstalled(stub shim.ChaincodeStubInterface, store *moduleStore, name, key string) (*chaincodeInfo, error) {
+	metadata, err := loadMetadata(stub, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.Size == 0 {
+		code, err := store.get(key)
+		if err != nil {
+			return nil, err
+		}
+		if metadata, err = loadMetadata(stub, name, code); err != nil {
+			return nil, err
+		}
+		metadata.Size = len(code)
+		metadata.Functions = codeFunctions(name, code)
+	}
+
+	info := &chaincodeInfo{
+		Name:        name,
+		Version:     metadata.Version,
+		CodeHash:    metadata.CodeHash,
+		Size:        metadata.Size,
+		CreatorMSP:  metadata.CreatorMSP,
+		CreateTxID:  metadata.CreateTxID,
+		CreatedAt:   metadata.CreatedAt,
+		UpgradeTxID: metadata.UpgradeTxID,
+		UpgradedAt:  metadata.UpgradedAt,
+		Status:      "active",
+		Functions:   metadata.Functions,
+	}
+	if metadata.Paused {
+		info.Status = "paused"
+	}
+	if info.Functions == nil {
+		info.Functions = []string{}
+	}
+	return info, nil
+}
+
+// codeFunctions returns the functions exported by the code of a chaincode, none if its
+// exports cannot be read.
+func codeFunctions(name string, code []byte) []string {
+	functions, err := moduleFunctionExports(code)
+	if err != nil {
+		logger.Warningf("Unable to read exports of %s: %s", name, err)
+	}
+	return functions
+}
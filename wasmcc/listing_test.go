@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// pagedStub is a mock stub with paginated queries, which the mock stub of the shim does not
+// implement. Like the peer it starts pages at the bookmark, a key, and gives back the key
+// following the page as bookmark.
+type pagedStub struct {
+	*shim.MockStub
+	args [][]byte
+}
+
+func (s *pagedStub) GetArgs() [][]byte {
+	return s.args
+}
+
+func (s *pagedStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string,
+	pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	partialKey, err := s.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	startKey, endKey := partialKey, partialKey+string(utf8.MaxRune)
+	if bookmark != "" {
+		startKey = bookmark
+	}
+
+	metadata := &pb.QueryResponseMetadata{}
+	iterator := shim.NewMockStateRangeQueryIterator(s.MockStub, startKey, endKey)
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if metadata.FetchedRecordsCount == pageSize {
+			metadata.Bookmark = entry.Key
+			endKey = entry.Key
+			break
+		}
+		metadata.FetchedRecordsCount++
+	}
+	return shim.NewMockStateRangeQueryIterator(s.MockStub, startKey, endKey), metadata, nil
+}
+
+// queryPaged invokes wasmcc with a stub answering paginated queries.
+func queryPaged(stub *shim.MockStub, args ...[]byte) pb.Response {
+	stub.MockTransactionStart("paged")
+	defer stub.MockTransactionEnd("paged")
+	return new(WASMChaincode).Invoke(&pagedStub{MockStub: stub, args: args})
+}
+
+var _ = Describe("Tests for listing installed chaincodes", func() {
+
+	status200 := int32(200)
+	status500 := int32(500)
+
+	module := buildTestModule(nil, []testFunc{
+		{name: "init", params: []byte{i64}, results: []byte{i64}, body: returnI64(0)},
+		{name: "query", params: []byte{i64}, results: []byte{i64}, body: returnI64(0)},
+	}, nil)
+
+	stub := shim.NewMockStub("listingStub", new(WASMChaincode))
+	stub.MockInit("000", nil)
+
+	list := func(args ...string) chaincodeList {
+		rawArgs := [][]byte{[]byte("installedChaincodes")}
+		for _, arg := range args {
+			rawArgs = append(rawArgs, []byte(arg))
+		}
+		result := queryPaged(stub, rawArgs...)
+		Expect(result.Status).Should(Equal(status200), result.Message)
+		list := chaincodeList{}
+		Expect(json.Unmarshal(result.Payload, &list)).Should(Succeed())
+		return list
+	}
+
+	It("should describe installed chaincodes", func() {
+		for _, name := range []string{"alpha1", "alpha2", "alpha3", "beta"} {
+			result := stub.MockInvoke(name, [][]byte{[]byte("create"), []byte(name), module})
+			Expect(result.Status).Should(Equal(status200), result.Message)
+		}
+		result := stub.MockInvoke("pause", [][]byte{[]byte("pause"), []byte("alpha2")})
+		Expect(result.Status).Should(Equal(status200), result.Message)
+
+		chaincodes := list().Chaincodes
+		Expect(chaincodes).Should(HaveLen(4))
+
+		info := chaincodes[0]
+		Expect(info.Name).Should(Equal("alpha1"))
+		Expect(info.Version).Should(Equal(uint64(1)))
+		Expect(info.CodeHash).Should(Equal(codeHash(module)))
+		Expect(info.Size).Should(Equal(len(module)))
+		Expect(info.CreateTxID).Should(Equal("alpha1"))
+		Expect(info.CreatedAt).ShouldNot(BeEmpty())
+		Expect(info.Status).Should(Equal("active"))
+		Expect(info.Functions).Should(Equal([]string{"init", "query"}))
+		Expect(chaincodes[1].Status).Should(Equal("paused"))
+
+		// the listing is read from the metadata, not the modules
+		metadata, err := loadMetadata(stub, "alpha1", nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(metadata.Size).Should(Equal(len(module)))
+		Expect(metadata.Functions).Should(Equal([]string{"init", "query"}))
+	})
+	It("should describe chaincodes stored before their size and functions were recorded", func() {
+		stub.MockTransactionStart("legacy")
+		key, _ := stub.CreateCompositeKey(chaincodeStoreIndex, []string{"legacy"})
+		Expect(stub.PutState(key, module)).Should(Succeed())
+		stub.MockTransactionEnd("legacy")
+
+		chaincodes := list("legacy").Chaincodes
+		Expect(chaincodes).Should(HaveLen(1))
+		Expect(chaincodes[0].CodeHash).Should(Equal(codeHash(module)))
+		Expect(chaincodes[0].Size).Should(Equal(len(module)))
+		Expect(chaincodes[0].Functions).Should(Equal([]string{"init", "query"}))
+	})
+	It("should list chaincodes by prefix in pages", func() {
+		page := list("alpha", "2")
+		Expect(page.Chaincodes).Should(HaveLen(2))
+		Expect(page.Chaincodes[1].Name).Should(Equal("alpha2"))
+		Expect(page.Bookmark).Should(Equal("alpha2"))
+
+		page = list("alpha", "2", page.Bookmark)
+		Expect(page.Chaincodes).Should(HaveLen(1))
+		Expect(page.Chaincodes[0].Name).Should(Equal("alpha3"))
+		Expect(page.Bookmark).Should(BeEmpty())
+
+		Expect(list("gamma").Chaincodes).Should(BeEmpty())
+	})
+	It("should start pages at the bookmark", func() {
+		for _, name := range []string{"delta1", "delta2", "delta3"} {
+			result := stub.MockInvoke(name, [][]byte{[]byte("create"), []byte(name), module})
+			Expect(result.Status).Should(Equal(status200), result.Message)
+		}
+
+		// the page of the ledger holds three chaincodes, starting at alpha1 it would hold none
+		// of the delta ones
+		page := list("", "1", "delta1")
+		Expect(page.Chaincodes).Should(HaveLen(1))
+		Expect(page.Chaincodes[0].Name).Should(Equal("delta2"))
+		Expect(page.Bookmark).Should(Equal("delta2"))
+
+		page = list("delta", "5", "alpha1")
+		Expect(page.Chaincodes).Should(HaveLen(3))
+		Expect(page.Chaincodes[0].Name).Should(Equal("delta1"))
+		Expect(page.Bookmark).Should(BeEmpty())
+	})
+	It("should reject invalid page sizes", func() {
+		result := queryPaged(stub, []byte("installedChaincodes"), []byte(""), []byte("0"))
+		Expect(result.Status).Should(Equal(status500))
+	})
+})
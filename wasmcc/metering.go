@@ -0,0 +1,817 @@
+//go:build wazero
+// +build wazero
+
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Exports added to metered modules. The host reads and charges the gas left, reads why the
+// metering code trapped, and runs the start function of the module itself.
+const (
+	meteringGasExport   = "__wasmcc_gas_left"
+	meteringTrapExport  = "__wasmcc_trap"
+	meteringStartExport = "__wasmcc_start"
+)
+
+// Reasons the metering code traps for, stored in the trap global before trapping
+const (
+	meteringTrapOutOfGas = 1 + iota
+	meteringTrapCallStackDepth
+	meteringTrapValueSlots
+)
+
+// meteringTrapMessages are the errors of life for the same limits, so both engines report
+// exceeded limits alike.
+var meteringTrapMessages = map[uint32]string{
+	meteringTrapOutOfGas:       lifeGasLimitExceeded,
+	meteringTrapCallStackDepth: "max call stack depth exceeded",
+	meteringTrapValueSlots:     "max value slot count exceeded",
+}
+
+// memory.copy, memory.fill and memory.init are charged one gas per this many bytes on top of
+// the cost of the instruction, as a power of two.
+const bulkMemoryBytesPerGasShift = 3
+
+// Section ids and opcodes the metering code refers to
+const (
+	wasmGlobalSectionID = 6
+	wasmStartSectionID  = 8
+
+	wasmOpUnreachable = 0x00
+	wasmOpBlock       = 0x02
+	wasmOpLoop        = 0x03
+	wasmOpIf          = 0x04
+	wasmOpElse        = 0x05
+	wasmOpEnd         = 0x0b
+	wasmOpBr          = 0x0c
+	wasmOpBrIf        = 0x0d
+	wasmOpBrTable     = 0x0e
+	wasmOpReturn      = 0x0f
+	wasmOpCall        = 0x10
+	wasmOpLocalGet    = 0x20
+	wasmOpLocalSet    = 0x21
+	wasmOpLocalTee    = 0x22
+	wasmOpGlobalGet   = 0x23
+	wasmOpGlobalSet   = 0x24
+	wasmOpI32Const    = 0x41
+	wasmOpI64Const    = 0x42
+	wasmOpI32GtU      = 0x4b
+	wasmOpI64LtU      = 0x54
+	wasmOpI32Add      = 0x6a
+	wasmOpI32Sub      = 0x6b
+	wasmOpI64Sub      = 0x7d
+	wasmOpI64ShrU     = 0x88
+	wasmOpI64ExtendU  = 0xad
+	wasmOpPrefixFC    = 0xfc
+)
+
+// Order of the known sections in a module, by section id
+var wasmSectionOrder = map[byte]int{1: 1, 2: 2, 3: 3, 4: 4, 5: 5, 13: 6, 6: 7, 7: 8, 8: 9, 9: 10, 12: 11, 10: 12, 11: 13}
+
+// meteredOpcodes names the opcodes whose cost differs from the default in the instruction
+// cost table shared with life.
+var meteredOpcodes = map[byte]string{
+	0x00: "unreachable",
+	0x0e: "jmp_table",
+	0x0f: "return",
+	0x10: "call",
+	0x11: "call_indirect",
+	0x3f: "memory.size",
+	0x40: "memory.grow",
+	0x6c: "i32.mul",
+	0x6d: "i32.div_s",
+	0x6e: "i32.div_u",
+	0x6f: "i32.rem_s",
+	0x70: "i32.rem_u",
+	0x7e: "i64.mul",
+	0x7f: "i64.div_s",
+	0x80: "i64.div_u",
+	0x81: "i64.rem_s",
+	0x82: "i64.rem_u",
+	0x91: "f32.sqrt",
+	0x95: "f32.div",
+	0x9f: "f64.sqrt",
+	0xa3: "f64.div",
+}
+
+// instructionCost returns the gas charged for an instruction. Instructions which only
+// structure the code are free.
+func instructionCost(op byte) uint64 {
+	switch {
+	case op == 0x01 || op == wasmOpBlock || op == wasmOpLoop || op == wasmOpEnd:
+		return 0
+	case op >= 0x28 && op <= 0x3e:
+		return memoryAccessGasCost
+	}
+	if name, ok := meteredOpcodes[op]; ok {
+		return uint64(instructionGasCosts[name])
+	}
+	return 1
+}
+
+// moduleLayout is what metering needs to know about a module besides the code of its
+// functions.
+type moduleLayout struct {
+	// params and results of every function type
+	types [][2]int
+	// type index of every function, imported functions first
+	functions       []uint32
+	importedFuncs   int
+	importedGlobals int
+	definedGlobals  int
+	start           int
+}
+
+// Globals added to metered modules, after the globals of the module
+const (
+	meteringGasGlobal = iota
+	meteringTrapGlobal
+	meteringDepthGlobal
+	meteringSlotsGlobal
+)
+
+// meterModule instruments a module to charge gas for its instructions and to enforce the call
+// stack depth and value slot limits, the way life does for the modules it runs. The gas of
+// every straight line run of instructions is charged when the run starts, from a global the
+// host charges host calls against too, so the gas used only depends on the module and its
+// inputs. Function indices are left unchanged, so names and stack traces still apply.
+func meterModule(code []byte, limits runtimeLimits) ([]byte, error) {
+	sections, err := readWasmSections(code)
+	if err != nil {
+		return nil, err
+	}
+	layout, err := readModuleLayout(sections)
+	if err != nil {
+		return nil, err
+	}
+
+	base := uint32(layout.importedGlobals + layout.definedGlobals)
+	meter := &functionMeter{
+		layout:   layout,
+		globals:  base,
+		gas:      base + meteringGasGlobal,
+		trap:     base + meteringTrapGlobal,
+		depth:    base + meteringDepthGlobal,
+		slots:    base + meteringSlotsGlobal,
+		maxDepth: clampLimit(limits.MaxCallStackDepth),
+		maxSlots: clampLimit(limits.MaxValueSlots),
+	}
+	gasLimit := limits.GasLimit
+	if gasLimit == 0 {
+		gasLimit = math.MaxUint64
+	}
+
+	var out []rawSection
+	hasGlobals, hasExports := false, false
+	for _, section := range sections {
+		payload := section.Payload
+		switch section.ID {
+		case wasmCustomSectionID:
+			payload = append(appendName(nil, section.Name), payload...)
+		case wasmGlobalSectionID:
+			hasGlobals = true
+			payload = appendGlobals(payload, gasLimit)
+		case wasmExportSectionID:
+			hasExports = true
+			payload = appendExports(payload, layout, base)
+		case wasmStartSectionID:
+			// the host runs the start function once the module is instantiated
+			continue
+		case wasmCodeSectionID:
+			if payload, err = meter.meterCode(payload); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, rawSection{id: section.ID, payload: payload})
+	}
+	if !hasGlobals {
+		out = insertSection(out, rawSection{id: wasmGlobalSectionID, payload: appendGlobals([]byte{0}, gasLimit)})
+	}
+	if !hasExports {
+		out = insertSection(out, rawSection{id: wasmExportSectionID, payload: appendExports([]byte{0}, layout, base)})
+	}
+
+	metered := append([]byte(nil), code[:8]...)
+	for _, section := range out {
+		metered = append(metered, section.id)
+		metered = appendULEB(metered, uint64(len(section.payload)))
+		metered = append(metered, section.payload...)
+	}
+	return metered, nil
+}
+
+func clampLimit(limit int) uint32 {
+	if limit <= 0 || limit > math.MaxInt32 {
+		return 0
+	}
+	return uint32(limit)
+}
+
+// readModuleLayout reads the function types, the functions and the globals of a module.
+func readModuleLayout(sections []wasmSection) (*moduleLayout, error) {
+	layout := &moduleLayout{start: -1}
+	for _, section := range sections {
+		r := &wasmReader{buf: section.Payload}
+		switch section.ID {
+		case wasmTypeSectionID:
+			count := int(r.u32())
+			for i := 0; i < count && r.err == nil; i++ {
+				if r.byte() != 0x60 {
+					return nil, errMalformedWasm
+				}
+				params := len(r.bytes(int(r.u32())))
+				results := len(r.bytes(int(r.u32())))
+				layout.types = append(layout.types, [2]int{params, results})
+			}
+		case wasmImportSectionID:
+			count := int(r.u32())
+			for i := 0; i < count && r.err == nil; i++ {
+				r.name()
+				r.name()
+				switch r.byte() {
+				case 0: // function
+					layout.functions = append(layout.functions, r.u32())
+					layout.importedFuncs++
+				case 1: // table
+					r.byte()
+					r.limits()
+				case 2: // memory
+					r.limits()
+				case 3: // global
+					r.byte()
+					r.byte()
+					layout.importedGlobals++
+				default:
+					return nil, errMalformedWasm
+				}
+			}
+		case wasmFunctionSectionID:
+			count := int(r.u32())
+			for i := 0; i < count && r.err == nil; i++ {
+				layout.functions = append(layout.functions, r.u32())
+			}
+		case wasmGlobalSectionID:
+			layout.definedGlobals = int(r.u32())
+		case wasmExportSectionID:
+			count := int(r.u32())
+			for i := 0; i < count && r.err == nil; i++ {
+				switch r.name() {
+				case meteringGasExport, meteringTrapExport, meteringStartExport:
+					return nil, fmt.Errorf("modules must not export names starting with __wasmcc_")
+				}
+				r.byte()
+				r.u32()
+			}
+		case wasmStartSectionID:
+			layout.start = int(r.u32())
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+	for _, typeIndex := range layout.functions {
+		if int(typeIndex) >= len(layout.types) {
+			return nil, errMalformedWasm
+		}
+	}
+	return layout, nil
+}
+
+// appendGlobals adds the metering globals to the payload of a global section: the gas left,
+// the reason of a trap, the call stack depth and the value slots in use.
+func appendGlobals(payload []byte, gasLimit uint64) []byte {
+	r := &wasmReader{buf: payload}
+	count := r.u32()
+	globals := appendULEB(nil, uint64(count+4))
+	globals = append(globals, payload[r.pos:]...)
+	globals = append(globals, 0x7e, 1, wasmOpI64Const)
+	globals = appendSLEB(globals, int64(gasLimit))
+	globals = append(globals, wasmOpEnd)
+	for i := 0; i < 3; i++ {
+		globals = append(globals, 0x7f, 1, wasmOpI32Const, 0, wasmOpEnd)
+	}
+	return globals
+}
+
+// appendExports adds the exports of the gas and trap globals, and of the start function, to
+// the payload of an export section.
+func appendExports(payload []byte, layout *moduleLayout, base uint32) []byte {
+	r := &wasmReader{buf: payload}
+	count := r.u32() + 2
+	if layout.start >= 0 {
+		count++
+	}
+	exports := appendULEB(nil, uint64(count))
+	exports = append(exports, payload[r.pos:]...)
+	exports = append(appendName(exports, meteringGasExport), 3)
+	exports = appendULEB(exports, uint64(base+meteringGasGlobal))
+	exports = append(appendName(exports, meteringTrapExport), 3)
+	exports = appendULEB(exports, uint64(base+meteringTrapGlobal))
+	if layout.start >= 0 {
+		exports = append(appendName(exports, meteringStartExport), 0)
+		exports = appendULEB(exports, uint64(layout.start))
+	}
+	return exports
+}
+
+// rawSection is a section of a metered module, custom sections include their name.
+type rawSection struct {
+	id      byte
+	payload []byte
+}
+
+// insertSection inserts a section after the last section which comes before it.
+func insertSection(sections []rawSection, section rawSection) []rawSection {
+	at := 0
+	for i, s := range sections {
+		if order, ok := wasmSectionOrder[s.id]; ok && order < wasmSectionOrder[section.id] {
+			at = i + 1
+		}
+	}
+	sections = append(sections, rawSection{})
+	copy(sections[at+1:], sections[at:])
+	sections[at] = section
+	return sections
+}
+
+func appendULEB(buf []byte, v uint64) []byte {
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(buf, b)
+		}
+		buf = append(buf, b|0x80)
+	}
+}
+
+func appendSLEB(buf []byte, v int64) []byte {
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0) {
+			return append(buf, b)
+		}
+		buf = append(buf, b|0x80)
+	}
+}
+
+func appendName(buf []byte, name string) []byte {
+	return append(appendULEB(buf, uint64(len(name))), name...)
+}
+
+// controlFrame is a block, loop, if or function body on the control stack of a function.
+type controlFrame struct {
+	height      int
+	params      int
+	results     int
+	unreachable bool
+}
+
+// functionMeter instruments the functions of a module.
+type functionMeter struct {
+	layout                  *moduleLayout
+	globals                 uint32
+	gas, trap, depth, slots uint32
+	maxDepth, maxSlots      uint32
+
+	// state of the function being metered
+	r          *wasmReader
+	emit       bool
+	out, run   []byte
+	runCost    uint64
+	frames     []controlFrame
+	height     int
+	maxHeight  int
+	frameSlots uint32
+	bulkMemory bool
+	scratch    uint32
+}
+
+// meterCode instruments every function body of a code section.
+func (m *functionMeter) meterCode(payload []byte) ([]byte, error) {
+	r := &wasmReader{buf: payload}
+	count := int(r.u32())
+	if count != len(m.layout.functions)-m.layout.importedFuncs {
+		return nil, errMalformedWasm
+	}
+	code := appendULEB(nil, uint64(count))
+	for i := 0; i < count && r.err == nil; i++ {
+		body := r.bytes(int(r.u32()))
+		if r.err != nil {
+			break
+		}
+		metered, err := m.meterFunction(m.layout.types[m.layout.functions[m.layout.importedFuncs+i]], body)
+		if err != nil {
+			return nil, fmt.Errorf("function %d: %s", m.layout.importedFuncs+i, err)
+		}
+		code = appendULEB(code, uint64(len(metered)))
+		code = append(code, metered...)
+	}
+	return code, r.err
+}
+
+// meterFunction instruments a function body in two passes, the first one finds the value
+// slots the function needs, which the second one charges on entry.
+func (m *functionMeter) meterFunction(signature [2]int, body []byte) ([]byte, error) {
+	r := &wasmReader{buf: body}
+	locals := uint64(signature[0])
+	declarations := r.u32()
+	firstDeclaration := r.pos
+	for i := uint32(0); i < declarations && r.err == nil; i++ {
+		locals += uint64(r.u32())
+		r.byte()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if locals > math.MaxInt32 {
+		return nil, errMalformedWasm
+	}
+	instructions := r.pos
+
+	for _, emit := range []bool{false, true} {
+		m.r = &wasmReader{buf: body, pos: instructions}
+		m.emit = emit
+		m.out, m.run, m.runCost = nil, nil, 0
+		m.frames = []controlFrame{{results: signature[1]}}
+		m.height = 0
+		m.scratch = uint32(locals)
+		if emit {
+			m.frameSlots = uint32(locals) + uint32(m.maxHeight)
+			m.entry()
+		} else {
+			m.maxHeight, m.bulkMemory = 0, false
+		}
+		for len(m.frames) > 0 {
+			if err := m.step(); err != nil {
+				return nil, err
+			}
+		}
+		if m.r.pos != len(body) {
+			return nil, errMalformedWasm
+		}
+	}
+
+	if !m.bulkMemory {
+		return append(append([]byte(nil), body[:instructions]...), m.out...), nil
+	}
+	// an i32 and an i64 scratch local for charging bulk memory instructions follow the locals
+	metered := appendULEB(nil, uint64(declarations)+2)
+	metered = append(metered, body[firstDeclaration:instructions]...)
+	metered = append(metered, 1, 0x7f, 1, 0x7e)
+	return append(metered, m.out...), nil
+}
+
+// step meters one instruction.
+func (m *functionMeter) step() error {
+	r := m.r
+	start := r.pos
+	op := r.byte()
+	cost := instructionCost(op)
+	// whether a new run of instructions starts after this one
+	endsRun := false
+	// code emitted in front of the instruction
+	var prefix []byte
+
+	switch {
+	case op == wasmOpUnreachable:
+		m.setUnreachable()
+		endsRun = true
+	case op == 0x01: // nop
+	case op == wasmOpBlock || op == wasmOpLoop || op == wasmOpIf:
+		if op == wasmOpIf {
+			m.pop(1)
+		}
+		params, results, err := m.blockType()
+		if err != nil {
+			return err
+		}
+		m.pop(params)
+		m.frames = append(m.frames, controlFrame{height: m.height, params: params, results: results})
+		m.push(params)
+		endsRun = op != wasmOpBlock
+	case op == wasmOpElse:
+		frame := m.frame()
+		m.height = frame.height + frame.params
+		frame.unreachable = false
+		endsRun = true
+	case op == wasmOpEnd:
+		frame := m.frame()
+		m.height = frame.height + frame.results
+		m.frames = m.frames[:len(m.frames)-1]
+		if len(m.frames) == 0 {
+			prefix = m.exit()
+		}
+		endsRun = true
+	case op == wasmOpBr:
+		r.u32()
+		m.setUnreachable()
+		endsRun = true
+	case op == wasmOpBrIf:
+		r.u32()
+		m.pop(1)
+		endsRun = true
+	case op == wasmOpBrTable:
+		count := int(r.u32())
+		for i := 0; i <= count && r.err == nil; i++ {
+			r.u32()
+		}
+		m.pop(1)
+		m.setUnreachable()
+		endsRun = true
+	case op == wasmOpReturn:
+		prefix = m.exit()
+		m.setUnreachable()
+		endsRun = true
+	case op == wasmOpCall:
+		function := int(r.u32())
+		if function >= len(m.layout.functions) {
+			return errMalformedWasm
+		}
+		signature := m.layout.types[m.layout.functions[function]]
+		m.pop(signature[0])
+		m.push(signature[1])
+	case op == 0x11: // call_indirect
+		typeIndex := int(r.u32())
+		r.u32()
+		if typeIndex >= len(m.layout.types) {
+			return errMalformedWasm
+		}
+		m.pop(1 + m.layout.types[typeIndex][0])
+		m.push(m.layout.types[typeIndex][1])
+	case op == 0x1a: // drop
+		m.pop(1)
+	case op == 0x1b: // select
+		m.pop(3)
+		m.push(1)
+	case op == 0x1c: // select with types
+		r.bytes(int(r.u32()))
+		m.pop(3)
+		m.push(1)
+	case op == wasmOpLocalGet || op == wasmOpGlobalGet:
+		if !m.ownIndex(op, r.u32()) {
+			return errMalformedWasm
+		}
+		m.push(1)
+	case op == wasmOpLocalSet || op == wasmOpGlobalSet:
+		if !m.ownIndex(op, r.u32()) {
+			return errMalformedWasm
+		}
+		m.pop(1)
+	case op == wasmOpLocalTee:
+		if !m.ownIndex(op, r.u32()) {
+			return errMalformedWasm
+		}
+	case op == 0x25: // table.get
+		r.u32()
+	case op == 0x26: // table.set
+		r.u32()
+		m.pop(2)
+	case op >= 0x28 && op <= 0x35: // loads
+		r.u32()
+		r.u32()
+	case op >= 0x36 && op <= 0x3e: // stores
+		r.u32()
+		r.u32()
+		m.pop(2)
+	case op == 0x3f: // memory.size
+		r.byte()
+		m.push(1)
+	case op == 0x40: // memory.grow
+		r.byte()
+	case op == wasmOpI32Const || op == wasmOpI64Const:
+		for r.err == nil && r.byte()&0x80 != 0 {
+		}
+		m.push(1)
+	case op == 0x43: // f32.const
+		r.bytes(4)
+		m.push(1)
+	case op == 0x44: // f64.const
+		r.bytes(8)
+		m.push(1)
+	case op == 0x45 || op == 0x50 || (op >= 0x67 && op <= 0x69) || (op >= 0x79 && op <= 0x7b) ||
+		(op >= 0x8b && op <= 0x91) || (op >= 0x99 && op <= 0x9f) || (op >= 0xa7 && op <= 0xc4):
+		// tests, unary operators and conversions
+	case op >= 0x46 && op <= 0xa6:
+		// comparisons and binary operators
+		m.pop(1)
+	case op == 0xd0: // ref.null
+		r.byte()
+		m.push(1)
+	case op == 0xd1: // ref.is_null
+	case op == 0xd2: // ref.func
+		r.u32()
+		m.push(1)
+	case op == wasmOpPrefixFC:
+		var err error
+		if prefix, err = m.stepFC(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("instruction 0x%02x is not supported", op)
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	if m.emit {
+		m.runCost += cost
+		m.run = append(m.run, prefix...)
+		m.run = append(m.run, r.buf[start:r.pos]...)
+		if endsRun {
+			m.out = append(m.out, m.charge(m.runCost)...)
+			m.out = append(m.out, m.run...)
+			m.run, m.runCost = m.run[:0], 0
+		}
+	}
+	return nil
+}
+
+// stepFC meters an instruction with the 0xfc prefix, returning the code charging the bytes
+// moved by bulk memory instructions.
+func (m *functionMeter) stepFC() ([]byte, error) {
+	r := m.r
+	op := r.u32()
+	switch {
+	case op <= 7: // saturating truncation
+	case op == 8: // memory.init
+		r.u32()
+		r.byte()
+		m.pop(3)
+		return m.chargeBytes(), nil
+	case op == 9 || op == 13: // data.drop, elem.drop
+		r.u32()
+	case op == 10: // memory.copy
+		r.byte()
+		r.byte()
+		m.pop(3)
+		return m.chargeBytes(), nil
+	case op == 11: // memory.fill
+		r.byte()
+		m.pop(3)
+		return m.chargeBytes(), nil
+	case op == 12 || op == 14: // table.init, table.copy
+		r.u32()
+		r.u32()
+		m.pop(3)
+	case op == 15: // table.grow
+		r.u32()
+		m.pop(1)
+	case op == 16: // table.size
+		r.u32()
+		m.push(1)
+	case op == 17: // table.fill
+		r.u32()
+		m.pop(3)
+	default:
+		return nil, fmt.Errorf("instruction 0xfc %d is not supported", op)
+	}
+	return nil, nil
+}
+
+// blockType returns the params and results of a block, loop or if.
+// ownIndex reports whether a local or global index refers to the locals or globals of the
+// module, and not to those added by metering.
+func (m *functionMeter) ownIndex(op byte, index uint32) bool {
+	if op == wasmOpGlobalGet || op == wasmOpGlobalSet {
+		return index < m.globals
+	}
+	return index < m.scratch
+}
+
+func (m *functionMeter) blockType() (int, int, error) {
+	r := m.r
+	if r.eof() {
+		return 0, 0, errMalformedWasm
+	}
+	switch b := r.buf[r.pos]; {
+	case b == 0x40:
+		r.pos++
+		return 0, 0, nil
+	case b >= 0x6f && b <= 0x7f:
+		r.pos++
+		return 0, 1, nil
+	}
+	typeIndex := int(r.u32())
+	if r.err != nil || typeIndex >= len(m.layout.types) {
+		return 0, 0, errMalformedWasm
+	}
+	return m.layout.types[typeIndex][0], m.layout.types[typeIndex][1], nil
+}
+
+func (m *functionMeter) frame() *controlFrame {
+	return &m.frames[len(m.frames)-1]
+}
+
+// pop removes values from the operand stack. Code after a branch may pop values which are
+// not there, the stack stays at the height of its block then.
+func (m *functionMeter) pop(n int) {
+	m.height -= n
+	if frame := m.frame(); m.height < frame.height {
+		m.height = frame.height
+	}
+}
+
+func (m *functionMeter) push(n int) {
+	m.height += n
+	if m.height > m.maxHeight {
+		m.maxHeight = m.height
+	}
+}
+
+func (m *functionMeter) setUnreachable() {
+	frame := m.frame()
+	m.height = frame.height
+	frame.unreachable = true
+}
+
+// trapWith sets the trap global and traps if the condition on the stack holds.
+func (m *functionMeter) trapWith(code []byte, reason byte) []byte {
+	code = append(code, wasmOpIf, 0x40, wasmOpI32Const, reason, wasmOpGlobalSet)
+	code = appendULEB(code, uint64(m.trap))
+	return append(code, wasmOpUnreachable, wasmOpEnd)
+}
+
+// charge subtracts a constant cost from the gas left, trapping if there is not enough gas.
+func (m *functionMeter) charge(cost uint64) []byte {
+	if cost == 0 {
+		return nil
+	}
+	var code []byte
+	code = m.getGlobal(code, m.gas)
+	code = append(code, wasmOpI64Const)
+	code = appendSLEB(code, int64(cost))
+	code = m.trapWith(append(code, wasmOpI64LtU), meteringTrapOutOfGas)
+	code = m.getGlobal(code, m.gas)
+	code = append(code, wasmOpI64Const)
+	code = appendSLEB(code, int64(cost))
+	code = append(code, wasmOpI64Sub)
+	return m.setGlobal(code, m.gas)
+}
+
+// chargeBytes charges the byte count on top of the stack of a bulk memory instruction,
+// keeping it on the stack.
+func (m *functionMeter) chargeBytes() []byte {
+	m.bulkMemory = true
+	count, cost := uint64(m.scratch), uint64(m.scratch+1)
+	code := appendULEB([]byte{wasmOpLocalTee}, count)
+	code = appendULEB(append(code, wasmOpLocalGet), count)
+	code = append(code, wasmOpI64ExtendU, wasmOpI64Const, bulkMemoryBytesPerGasShift, wasmOpI64ShrU)
+	code = appendULEB(append(code, wasmOpLocalSet), cost)
+	code = m.getGlobal(code, m.gas)
+	code = appendULEB(append(code, wasmOpLocalGet), cost)
+	code = m.trapWith(append(code, wasmOpI64LtU), meteringTrapOutOfGas)
+	code = m.getGlobal(code, m.gas)
+	code = appendULEB(append(code, wasmOpLocalGet), cost)
+	code = append(code, wasmOpI64Sub)
+	return m.setGlobal(code, m.gas)
+}
+
+// entry emits the code entering a function, which counts the frame and the value slots of the
+// function against the limits.
+func (m *functionMeter) entry() {
+	m.out = m.enter(m.out, m.depth, 1, m.maxDepth, meteringTrapCallStackDepth)
+	m.out = m.enter(m.out, m.slots, m.frameSlots, m.maxSlots, meteringTrapValueSlots)
+}
+
+func (m *functionMeter) enter(code []byte, global, n, limit uint32, reason byte) []byte {
+	if limit == 0 {
+		return code
+	}
+	code = m.addGlobal(code, global, n, wasmOpI32Add)
+	code = m.getGlobal(code, global)
+	code = append(code, wasmOpI32Const)
+	code = appendSLEB(code, int64(int32(limit)))
+	return m.trapWith(append(code, wasmOpI32GtU), reason)
+}
+
+// exit returns the code leaving a function, which releases what entry counted.
+func (m *functionMeter) exit() []byte {
+	var code []byte
+	if m.maxDepth != 0 {
+		code = m.addGlobal(code, m.depth, 1, wasmOpI32Sub)
+	}
+	if m.maxSlots != 0 {
+		code = m.addGlobal(code, m.slots, m.frameSlots, wasmOpI32Sub)
+	}
+	return code
+}
+
+func (m *functionMeter) addGlobal(code []byte, global, n uint32, op byte) []byte {
+	code = m.getGlobal(code, global)
+	code = append(code, wasmOpI32Const)
+	code = appendSLEB(code, int64(int32(n)))
+	return m.setGlobal(append(code, op), global)
+}
+
+func (m *functionMeter) getGlobal(code []byte, global uint32) []byte {
+	return appendULEB(append(code, wasmOpGlobalGet), uint64(global))
+}
+
+func (m *functionMeter) setGlobal(code []byte, global uint32) []byte {
+	return appendULEB(append(code, wasmOpGlobalSet), uint64(global))
+}
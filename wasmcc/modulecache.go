@@ -0,0 +1,137 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+
+	"github.com/perlin-network/life/exec"
+	wasm_validation "github.com/perlin-network/life/wasm-validation"
+)
+
+// Memory the compiled module cache may hold before least recently used modules are evicted.
+var moduleCacheCapacity = 256 * 1024 * 1024
+
+// compiledModules caches validated and compiled wasm modules across invocations, so repeated
+// calls of a chaincode only instantiate a fresh vm.
+var compiledModules = newModuleCache(moduleCacheCapacity)
+
+func init() {
+	compiledModules.onEvict = func(key moduleCacheKey) {
+		dropVMPools(key)
+		dropNativeCode(key)
+	}
+}
+
+// moduleCacheKey identifies a compiled module. Besides the code, compilation depends on the
+// memory and table given to modules which import them.
+type moduleCacheKey struct {
+	codeHash    [sha256.Size]byte
+	memoryPages int
+	tableSize   int
+	maxTable    int
+}
+
+func newModuleCacheKey(code []byte, config exec.VMConfig) moduleCacheKey {
+	return moduleCacheKey{
+		codeHash:    sha256.Sum256(code),
+		memoryPages: config.DefaultMemoryPages,
+		tableSize:   config.DefaultTableSize,
+		maxTable:    config.MaxTableSize,
+	}
+}
+
+type moduleCacheEntry struct {
+	key    moduleCacheKey
+	module *exec.Module
+	size   int
+}
+
+// moduleCache is a least recently used cache of compiled modules bounded by their estimated
+// memory size. It is safe for concurrent use, cached modules are never modified.
+type moduleCache struct {
+	mu       sync.Mutex
+	capacity int
+	size     int
+	order    *list.List
+	entries  map[moduleCacheKey]*list.Element
+	// onEvict is called with the key of every evicted module
+	onEvict func(key moduleCacheKey)
+}
+
+func newModuleCache(capacity int) *moduleCache {
+	return &moduleCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[moduleCacheKey]*list.Element),
+	}
+}
+
+func (c *moduleCache) get(key moduleCacheKey) (*exec.Module, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*moduleCacheEntry).module, true
+}
+
+func (c *moduleCache) add(key moduleCacheKey, module *exec.Module, size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; ok || size > c.capacity {
+		return
+	}
+	c.entries[key] = c.order.PushFront(&moduleCacheEntry{key: key, module: module, size: size})
+	c.size += size
+
+	for c.size > c.capacity {
+		oldest := c.order.Back()
+		entry := oldest.Value.(*moduleCacheEntry)
+		c.order.Remove(oldest)
+		delete(c.entries, entry.key)
+		c.size -= entry.size
+		if c.onEvict != nil {
+			c.onEvict(entry.key)
+		}
+	}
+}
+
+func (c *moduleCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// compiledModuleSize estimates the memory held by a compiled module.
+func compiledModuleSize(code []byte, module *exec.Module) int {
+	size := 2*len(code) + 4*len(module.Table) + 8*len(module.Globals)
+	for _, function := range module.FunctionCode {
+		size += len(function.Bytes)
+	}
+	return size
+}
+
+// loadModule returns the compiled module for the code, validating and compiling it only if
+// it is not cached yet.
+func loadModule(key moduleCacheKey, code []byte, config exec.VMConfig, r *Resolver) (*exec.Module, error) {
+	if module, ok := compiledModules.get(key); ok {
+		return module, nil
+	}
+
+	if err := wasm_validation.ValidateWasm(code); err != nil {
+		return nil, err
+	}
+	module, err := exec.NewModule(code, config, r, gasPolicy{})
+	if err != nil {
+		return nil, err
+	}
+	// the resolver of an invocation is set on each vm, the cache must not hold on to it
+	module.ImportResolver = nil
+	compiledModules.add(key, module, compiledModuleSize(code, module))
+	return module, nil
+}
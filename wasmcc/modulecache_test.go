@@ -0,0 +1,67 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/perlin-network/life/exec"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tests for the compiled module cache", func() {
+
+	status200 := int32(200)
+
+	Describe("LRU eviction", func() {
+		key := func(b byte) moduleCacheKey {
+			return moduleCacheKey{codeHash: [32]byte{b}}
+		}
+
+		It("should evict the least recently used module when full", func() {
+			cache := newModuleCache(100)
+			cache.add(key(1), &exec.Module{}, 40)
+			cache.add(key(2), &exec.Module{}, 40)
+			_, ok := cache.get(key(1))
+			Expect(ok).Should(BeTrue())
+
+			cache.add(key(3), &exec.Module{}, 40)
+			Expect(cache.len()).Should(Equal(2))
+			_, ok = cache.get(key(2))
+			Expect(ok).Should(BeFalse())
+			_, ok = cache.get(key(1))
+			Expect(ok).Should(BeTrue())
+		})
+		It("should not cache modules larger than the capacity", func() {
+			cache := newModuleCache(100)
+			cache.add(key(1), &exec.Module{}, 101)
+			Expect(cache.len()).Should(Equal(0))
+		})
+	})
+
+	Describe("Cached execution", func() {
+		stub := shim.NewMockStub("moduleCacheStub", new(WASMChaincode))
+		stub.MockInit("000", nil)
+
+		It("should reuse the module compiled by create", func() {
+			result := stub.MockInvoke("000",
+				[][]byte{[]byte("create"), []byte("cachedwasm"), ReadAssetTransferWASM(),
+					[]byte("account1"), []byte("100"), []byte("account2"), []byte("1000")})
+			Expect(result.Status).Should(Equal(status200))
+
+			config := defaultRuntimeLimits().vmConfig()
+			cached, err := loadModule(newModuleCacheKey(ReadAssetTransferWASM(), config), ReadAssetTransferWASM(), config, &Resolver{})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			for i := 0; i < 2; i++ {
+				result = stub.MockInvoke("000",
+					[][]byte{[]byte("execute"), []byte("cachedwasm"), []byte("query"), []byte("account1")})
+				Expect(result.Status).Should(Equal(status200))
+				Expect(string(result.Payload)).Should(Equal("100"))
+			}
+
+			module, err := loadModule(newModuleCacheKey(ReadAssetTransferWASM(), config), ReadAssetTransferWASM(), config, &Resolver{})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(module).Should(BeIdenticalTo(cached))
+		})
+	})
+})
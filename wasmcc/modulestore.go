@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Index names for wasm modules stored once by the hash of their code, and for the number of
+// names, versions and tags pointing to them
+var (
+	moduleStoreIndex = "wasmModule"
+	moduleRefsIndex  = "wasmModuleRefs"
+)
+
+// Index name for tags of wasm chaincodes, aliases like token:stable pointing to a module
+var chaincodeTagIndex = "chaincodeTag"
+
+// isCodePointer reports whether the value of a name, version or tag is the hash of a stored
+// module. Chaincodes created before modules were content addressed hold their code instead,
+// which starts with the wasm magic number and so is never a hex encoded hash.
+func isCodePointer(value []byte) bool {
+	if len(value) != 2*sha256.Size {
+		return false
+	}
+	_, err := hex.DecodeString(string(value))
+	return err == nil
+}
+
+// moduleStore resolves and updates pointers to modules. Writes are buffered, so reference
+// counts changed twice by a transaction are counted right, and applied by flush.
+type moduleStore struct {
+	stub  shim.ChaincodeStubInterface
+	state *stateOverlay
+}
+
+func newModuleStore(stub shim.ChaincodeStubInterface) *moduleStore {
+	return &moduleStore{stub: stub, state: newStateOverlay(stub)}
+}
+
+func (m *moduleStore) moduleKey(hash string) (string, error) {
+	return m.stub.CreateCompositeKey(moduleStoreIndex, []string{hash})
+}
+
+func (m *moduleStore) refsKey(hash string) (string, error) {
+	return m.stub.CreateCompositeKey(moduleRefsIndex, []string{hash})
+}
+
+// get returns the code a name, version or tag key points to, nil if the key does not exist.
+func (m *moduleStore) get(key string) ([]byte, error) {
+	value, err := m.state.get(key)
+	if err != nil || value == nil || !isCodePointer(value) {
+		return value, err
+	}
+
+	moduleKey, err := m.moduleKey(string(value))
+	if err != nil {
+		return nil, err
+	}
+	code, err := m.state.get(moduleKey)
+	if err != nil {
+		return nil, err
+	}
+	if code == nil {
+		return nil, fmt.Errorf("module %s is missing", value)
+	}
+	return code, nil
+}
+
+// refs returns the number of pointers to a module.
+func (m *moduleStore) refs(hash string) (uint64, error) {
+	key, err := m.refsKey(hash)
+	if err != nil {
+		return 0, err
+	}
+	value, err := m.state.get(key)
+	if err != nil || value == nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(value), 10, 64)
+}
+
+func (m *moduleStore) addRefs(hash string, delta int) error {
+	refs, err := m.refs(hash)
+	if err != nil {
+		return err
+	}
+	if delta < 0 && refs < uint64(-delta) {
+		return fmt.Errorf("module %s has %d references, cannot remove %d", hash, refs, -delta)
+	}
+	key, err := m.refsKey(hash)
+	if err != nil {
+		return err
+	}
+	m.state.put(key, []byte(strconv.FormatUint(uint64(int64(refs)+int64(delta)), 10)))
+	return nil
+}
+
+// point makes a key point to code, storing the code unless a module with the same hash is
+// stored already.
+func (m *moduleStore) point(key string, code []byte) error {
+	hash := codeHash(code)
+	moduleKey, err := m.moduleKey(hash)
+	if err != nil {
+		return err
+	}
+	stored, err := m.state.get(moduleKey)
+	if err != nil {
+		return err
+	}
+	if stored == nil {
+		m.state.put(moduleKey, code)
+	}
+
+	if err := m.addRefs(hash, 1); err != nil {
+		return err
+	}
+	if err := m.unpoint(key); err != nil {
+		return err
+	}
+	m.state.put(key, []byte(hash))
+	return nil
+}
+
+// unpoint deletes a key, releasing the module it points to.
+func (m *moduleStore) unpoint(key string) error {
+	value, err := m.state.get(key)
+	if err != nil || value == nil {
+		return err
+	}
+	if isCodePointer(value) {
+		if err := m.addRefs(string(value), -1); err != nil {
+			return err
+		}
+	}
+	m.state.del(key)
+	return nil
+}
+
+func (m *moduleStore) flush() error {
+	return m.state.flush()
+}
+
+func chaincodeTagKey(stub shim.ChaincodeStubInterface, chaincodeName, tag string) (string, error) {
+	return stub.CreateCompositeKey(chaincodeTagIndex, []string{chaincodeName, tag})
+}
+
+// setTag points a tag of a chaincode, executed as name:tag, to one of its versions. Receives
+// chaincode name, tag and version.
+func (t *WASMChaincode) setTag(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode name, tag and version")
+	}
+	chaincodeName, tag := args[0], args[1]
+	if tag == "" {
+		return shim.Error(fmt.Sprintf(InvalidConfig, "empty tag"))
+	}
+	version, err := strconv.ParseUint(args[2], 10, 64)
+	if err != nil || version == 0 {
+		return shim.Error(fmt.Sprintf(InvalidConfig, "invalid chaincode version "+args[2]))
+	}
+
+	code, errResponse := loadChaincodeCode(stub, chaincodeRef{name: chaincodeName, version: version})
+	if errResponse != nil {
+		return *errResponse
+	}
+	if errResponse := checkOwnerOrAdmin(stub, chaincodeName); errResponse != nil {
+		return *errResponse
+	}
+	key, err := chaincodeTagKey(stub, chaincodeName, tag)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	store := newModuleStore(stub)
+	if err := store.point(key, code); err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	if err := store.flush(); err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	return shim.Success([]byte(codeHash(code)))
+}
+
+// removeTag deletes a tag of a chaincode. Receives chaincode name and tag.
+func (t *WASMChaincode) removeTag(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode name and tag")
+	}
+	if errResponse := checkOwnerOrAdmin(stub, args[0]); errResponse != nil {
+		return *errResponse
+	}
+	key, err := chaincodeTagKey(stub, args[0], args[1])
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	store := newModuleStore(stub)
+	value, err := store.state.get(key)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	if value == nil {
+		return shim.Error("{\"Error\":\"No tag " + args[1] + " of Chaincode " + args[0] + "\"}")
+	}
+	if err := store.unpoint(key); err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	if err := store.flush(); err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	return shim.Success(nil)
+}
+
+// collectModules deletes the stored modules no name, version or tag points to any more, and
+// gives back their hashes as json. Only admins may collect modules.
+func (t *WASMChaincode) collectModules(stub shim.ChaincodeStubInterface) pb.Response {
+	if errResponse := checkAdmin(stub); errResponse != nil {
+		return *errResponse
+	}
+
+	iterator, err := stub.GetStateByPartialCompositeKey(moduleRefsIndex, []string{})
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	defer iterator.Close()
+
+	store := newModuleStore(stub)
+	collected := []string{}
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+		}
+		if string(entry.Value) != "0" {
+			continue
+		}
+		_, keyParts, err := stub.SplitCompositeKey(entry.Key)
+		if err != nil {
+			return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+		}
+		hash := keyParts[0]
+		moduleKey, err := store.moduleKey(hash)
+		if err != nil {
+			return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+		}
+		store.state.del(moduleKey)
+		store.state.del(entry.Key)
+		collected = append(collected, hash)
+	}
+	if err := store.flush(); err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+
+	logger.Infof("Collected %d unused wasm modules", len(collected))
+	collectedBytes, err := json.Marshal(collected)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	return shim.Success(collectedBytes)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tests for content addressed module storage", func() {
+
+	status200 := int32(200)
+	status500 := int32(500)
+
+	tokenModule := func(version int64) []byte {
+		return buildTestModule(nil, []testFunc{
+			{name: "init", params: []byte{i64}, results: []byte{i64}, body: returnI64(0)},
+			{name: "version", params: []byte{i64}, results: []byte{i64}, body: returnI64(version)},
+		}, nil)
+	}
+	v1, v2 := tokenModule(1), tokenModule(2)
+
+	stub := newAdminStub("moduleStoreStub")
+
+	// storedModules returns the number of modules stored on the ledger
+	storedModules := func() int {
+		count := 0
+		for key := range stub.State {
+			if strings.HasPrefix(key, "\x00"+moduleStoreIndex+"\x00") {
+				count++
+			}
+		}
+		return count
+	}
+	refs := func(code []byte) uint64 {
+		stub.MockTransactionStart("refs")
+		defer stub.MockTransactionEnd("refs")
+		refs, err := newModuleStore(stub).refs(codeHash(code))
+		Expect(err).ShouldNot(HaveOccurred())
+		return refs
+	}
+	versionOf := func(ref string) string {
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("execute"), []byte(ref), []byte("version")})
+		Expect(result.Status).Should(Equal(status200), result.Message)
+		return string(result.Payload)
+	}
+
+	It("should store the code of chaincodes once", func() {
+		for _, name := range []string{"tokena", "tokenb"} {
+			result := stub.MockInvoke("000",
+				[][]byte{[]byte("create"), []byte(name), v1})
+			Expect(result.Status).Should(Equal(status200))
+		}
+		Expect(storedModules()).Should(Equal(1))
+		// names and first versions of both chaincodes
+		Expect(refs(v1)).Should(Equal(uint64(4)))
+		Expect(versionOf("tokenb")).Should(Equal("1"))
+	})
+	It("should execute tagged versions", func() {
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("upgrade"), []byte("tokena"), v2})
+		Expect(result.Status).Should(Equal(status200))
+		Expect(storedModules()).Should(Equal(2))
+		Expect(refs(v1)).Should(Equal(uint64(3)))
+
+		result = stub.MockInvoke("000",
+			[][]byte{[]byte("setTag"), []byte("tokena"), []byte("stable"), []byte("1")})
+		Expect(result.Status).Should(Equal(status200))
+		Expect(string(result.Payload)).Should(Equal(codeHash(v1)))
+		Expect(refs(v1)).Should(Equal(uint64(4)))
+
+		Expect(versionOf("tokena")).Should(Equal("2"))
+		Expect(versionOf("tokena:stable")).Should(Equal("1"))
+		Expect(versionOf("tokena:stable#" + codeHash(v1))).Should(Equal("1"))
+	})
+	It("should remove tags", func() {
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("removeTag"), []byte("tokena"), []byte("stable")})
+		Expect(result.Status).Should(Equal(status200))
+		Expect(refs(v1)).Should(Equal(uint64(3)))
+
+		result = stub.MockInvoke("000",
+			[][]byte{[]byte("execute"), []byte("tokena:stable"), []byte("version")})
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(ContainSubstring("No tag stable of Chaincode tokena"))
+	})
+	It("should collect modules nothing points to", func() {
+		unused := tokenModule(3)
+		stub.MockTransactionStart("unused")
+		store := newModuleStore(stub)
+		Expect(store.point("unused", unused)).Should(Succeed())
+		Expect(store.unpoint("unused")).Should(Succeed())
+		Expect(store.flush()).Should(Succeed())
+		stub.MockTransactionEnd("unused")
+		Expect(storedModules()).Should(Equal(3))
+
+		result := invokeAsAdmin(stub, []byte("collectModules"))
+		Expect(result.Status).Should(Equal(status200))
+		Expect(string(result.Payload)).Should(Equal(`["` + codeHash(unused) + `"]`))
+		Expect(storedModules()).Should(Equal(2))
+		Expect(versionOf("tokena@1")).Should(Equal("1"))
+	})
+	It("should run and upgrade chaincodes stored before modules were content addressed", func() {
+		stub.MockTransactionStart("legacy")
+		key, _ := stub.CreateCompositeKey(chaincodeStoreIndex, []string{"legacy"})
+		Expect(stub.PutState(key, v1)).Should(Succeed())
+		stub.MockTransactionEnd("legacy")
+		Expect(versionOf("legacy")).Should(Equal("1"))
+
+		// chaincodes without owner are managed by admins
+		result := invokeAsAdmin(stub, []byte("upgrade"), []byte("legacy"), v2)
+		Expect(result.Status).Should(Equal(status200))
+		Expect(string(stub.State[key])).Should(Equal(codeHash(v2)))
+		Expect(versionOf("legacy@1")).Should(Equal("1"))
+		Expect(versionOf("legacy")).Should(Equal("2"))
+	})
+})
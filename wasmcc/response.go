@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// validResponseStatus reports whether a wasm chaincode may respond with a status: success
+// codes 2xx, client errors 4xx and server errors 5xx.
+func validResponseStatus(status int32) bool {
+	return (status >= 200 && status < 300) || (status >= shim.ERRORTHRESHOLD && status < 600)
+}
+
+// setResponse stores the response of the transaction set by the chaincode. Message and
+// payload are copied, wasm memory is released after the invocation.
+func (r *Resolver) setResponse(status int32, message, payload []byte) error {
+	if !validResponseStatus(status) {
+		return fmt.Errorf("invalid response status %d, expecting 2xx, 4xx or 5xx", status)
+	}
+	r.response = &pb.Response{
+		Status:  status,
+		Message: string(message),
+		Payload: append([]byte(nil), payload...),
+	}
+	return nil
+}
+
+// invocationResponse maps the outcome of an invocation to the response of the transaction. A
+// response set by the chaincode is returned exactly as it was set instead of its return value,
+// except a success response set by a function which then failed by returning -1. A function
+// failing without a result fails with the message it passed to __get_exception_msg, if any.
+func invocationResponse(result int64, r *Resolver) pb.Response {
+	if r.response != nil && (result != -1 || r.response.Status >= shim.ERRORTHRESHOLD) {
+		return *r.response
+	}
+	if result == -1 && r.result == nil && r.exceptionMsg != nil {
+		return shim.Error(string(r.exceptionMsg))
+	}
+	return txnResult(result, r.result)
+}
+
+// invocationSucceeded reports whether the state changes of an invocation are applied.
+func invocationSucceeded(result int64, r *Resolver) bool {
+	return invocationResponse(result, r).Status < shim.ERRORTHRESHOLD
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tests for responses set by wasm chaincodes", func() {
+
+	status200 := int32(200)
+	status500 := int32(500)
+
+	imports := []testImport{
+		{field: "__set_response", params: []byte{i32, i32, i32, i32, i32}, results: []byte{i64}},
+		{field: "__put_state", params: []byte{i32, i32, i32, i32}, results: []byte{i64}},
+	}
+	const setResponse, putState = 0, 1
+
+	// data holds the message "missing", the payload "ok" and the key value pair k=v
+	data := []byte("missingokkv")
+	respond := func(status int64, msgPtr, msgLen, payloadPtr, payloadLen byte) []byte {
+		return concat([]byte{opI32Const}, sleb(status),
+			[]byte{opI32Const, msgPtr, opI32Const, msgLen, opI32Const, payloadPtr, opI32Const, payloadLen, opCall, setResponse})
+	}
+	put := []byte{opI32Const, 9, opI32Const, 1, opI32Const, 10, opI32Const, 1, opCall, putState, opDrop}
+
+	responseModule := buildTestModule(imports, []testFunc{
+		{name: "init", params: []byte{i64}, results: []byte{i64}, body: returnI64(0)},
+		{name: "notfound", params: []byte{i64}, results: []byte{i64}, body: concat(respond(404, 0, 7, 0, 0), []byte{opDrop}, returnI64(-1))},
+		{name: "created", params: []byte{i64}, results: []byte{i64}, body: concat(put, respond(201, 0, 0, 7, 2))},
+		{name: "rejected", params: []byte{i64}, results: []byte{i64}, body: concat(put, respond(400, 0, 7, 0, 0))},
+		{name: "accepted", params: []byte{i64}, results: []byte{i64}, body: concat(respond(202, 0, 7, 7, 2), []byte{opDrop}, returnI64(0))},
+		{name: "redirect", params: []byte{i64}, results: []byte{i64}, body: respond(302, 0, 0, 0, 0)},
+		{name: "failed", params: []byte{i64}, results: []byte{i64}, body: concat(respond(200, 0, 0, 7, 2), []byte{opDrop}, returnI64(-1))},
+	}, data)
+
+	stub := shim.NewMockStub("responseStub", new(WASMChaincode))
+	stub.MockInit("000", nil)
+
+	It("should be created", func() {
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("create"), []byte("responsewasm"), responseModule})
+		Expect(result.Status).Should(Equal(status200))
+	})
+	It("should respond with the status and message set by the chaincode", func() {
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("execute"), []byte("responsewasm"), []byte("notfound")})
+		Expect(result).Should(Equal(pb.Response{Status: 404, Message: "missing"}))
+
+		result = stub.MockInvoke("000",
+			[][]byte{[]byte("execute"), []byte("responsewasm"), []byte("accepted")})
+		Expect(result).Should(Equal(pb.Response{Status: 202, Message: "missing", Payload: []byte("ok")}))
+	})
+	It("should apply state changes of success responses", func() {
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("execute"), []byte("responsewasm"), []byte("created")})
+		Expect(result).Should(Equal(pb.Response{Status: 201, Payload: []byte("ok")}))
+		Expect(string(stub.State["responsewasm_k"])).Should(Equal("v"))
+	})
+	It("should not apply state changes of error responses", func() {
+		delete(stub.State, "responsewasm_k")
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("execute"), []byte("responsewasm"), []byte("rejected")})
+		Expect(result).Should(Equal(pb.Response{Status: 400, Message: "missing"}))
+		Expect(stub.State).ShouldNot(HaveKey("responsewasm_k"))
+	})
+	It("should reject status codes other than 2xx, 4xx and 5xx", func() {
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("execute"), []byte("responsewasm"), []byte("redirect")})
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(Equal("-1"))
+	})
+	It("should fail functions returning -1 after setting a success response", func() {
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("execute"), []byte("responsewasm"), []byte("failed")})
+		Expect(result.Status).Should(Equal(status500))
+	})
+})
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// stateOverlay lets a wasm invocation read its own writes. Fabric only applies writes when
+// the transaction commits, so writes are buffered and reads are served from the buffer
+// before the ledger is read. Values read from the ledger are cached, a key is read at most
+// once per invocation.
+type stateOverlay struct {
+	stub   shim.ChaincodeStubInterface
+	reads  map[string][]byte
+	writes map[string]pendingWrite
+}
+
+// pendingWrite is a buffered write or delete of a key.
+type pendingWrite struct {
+	value   []byte
+	deleted bool
+}
+
+func newStateOverlay(stub shim.ChaincodeStubInterface) *stateOverlay {
+	return &stateOverlay{
+		stub:   stub,
+		reads:  make(map[string][]byte),
+		writes: make(map[string]pendingWrite),
+	}
+}
+
+// get returns the value of a key as seen by the invocation, nil if it does not exist.
+func (s *stateOverlay) get(key string) ([]byte, error) {
+	if write, ok := s.writes[key]; ok {
+		if write.deleted {
+			return nil, nil
+		}
+		return write.value, nil
+	}
+	if value, ok := s.reads[key]; ok {
+		return value, nil
+	}
+
+	value, err := s.stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	s.reads[key] = value
+	return value, nil
+}
+
+// put buffers a write. The value must not be modified afterwards.
+func (s *stateOverlay) put(key string, value []byte) {
+	s.writes[key] = pendingWrite{value: value}
+}
+
+// del buffers a delete.
+func (s *stateOverlay) del(key string) {
+	s.writes[key] = pendingWrite{deleted: true}
+}
+
+// flush applies the buffered writes to the stub, in key order so every peer issues them in
+// the same order.
+func (s *stateOverlay) flush() error {
+	keys := make([]string, 0, len(s.writes))
+	for key := range s.writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		write := s.writes[key]
+		var err error
+		if write.deleted {
+			err = s.stub.DelState(key)
+		} else {
+			err = s.stub.PutState(key, write.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	s.writes = make(map[string]pendingWrite)
+	return nil
+}
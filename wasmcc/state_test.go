@@ -0,0 +1,84 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// countingStub counts the reads of the ledger.
+type countingStub struct {
+	*shim.MockStub
+	reads int
+}
+
+func (s *countingStub) GetState(key string) ([]byte, error) {
+	s.reads++
+	return s.MockStub.GetState(key)
+}
+
+var _ = Describe("Tests for the transaction local state overlay", func() {
+
+	status200 := int32(200)
+	status500 := int32(500)
+
+	// host functions imported by the module, in order of their function index
+	imports := []testImport{
+		{field: "__put_state", params: []byte{i32, i32, i32, i32}, results: []byte{i64}},
+		{field: "__get_state", params: []byte{i32, i32, i32}, results: []byte{i64}},
+		{field: "__get_state_size", params: []byte{i32, i32}, results: []byte{i64}},
+		{field: "__delete_state", params: []byte{i32, i32}, results: []byte{i64}},
+		{field: "__return_result", params: []byte{i32, i32}, results: []byte{i64}},
+	}
+	const putState, getState, getStateSize, deleteState, returnResult = 0, 1, 2, 3, 4
+
+	// memory holds the key "k" at 0 and the value "v2" at 1
+	putKey := []byte{opI32Const, 0, opI32Const, 1, opI32Const, 1, opI32Const, 2, opCall, putState, opDrop}
+	getKey := []byte{opI32Const, 0, opI32Const, 1, opI32Const, 16, opCall, getState}
+	returnValue := []byte{opI32Const, 16, opI32Const, 2, opCall, returnResult, opDrop}
+
+	overlayModule := buildTestModule(imports, []testFunc{
+		{name: "init", params: []byte{i64}, results: []byte{i64}, body: returnI64(0)},
+		{name: "putget", params: []byte{i64}, results: []byte{i64}, body: concat(putKey, getKey, []byte{opDrop}, returnValue, returnI64(0))},
+		{name: "deleteget", params: []byte{i64}, results: []byte{i64}, body: concat(putKey, []byte{opI32Const, 0, opI32Const, 1, opCall, deleteState, opDrop}, getKey)},
+		{name: "putfail", params: []byte{i64}, results: []byte{i64}, body: concat(putKey, []byte{opUnreachable})},
+		{name: "sizeget", params: []byte{i64}, results: []byte{i64}, body: concat([]byte{opI32Const, 0, opI32Const, 1, opCall, getStateSize, opDrop}, getKey)},
+	}, []byte("kv2"))
+
+	stub := shim.NewMockStub("stateOverlayStub", new(WASMChaincode))
+	stub.MockInit("000", nil)
+
+	It("should be created", func() {
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("create"), []byte("overlaywasm"), overlayModule})
+		Expect(result.Status).Should(Equal(status200))
+	})
+	It("should discard writes of failed invocations", func() {
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("execute"), []byte("overlaywasm"), []byte("putfail")})
+		Expect(result.Status).Should(Equal(status500))
+		Expect(stub.State).ShouldNot(HaveKey("overlaywasm_k"))
+	})
+	It("should read writes of the same invocation", func() {
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("execute"), []byte("overlaywasm"), []byte("putget")})
+		Expect(result.Status).Should(Equal(status200))
+		Expect(string(result.Payload)).Should(Equal("v2"))
+		Expect(string(stub.State["overlaywasm_k"])).Should(Equal("v2"))
+	})
+	It("should not read keys deleted by the same invocation", func() {
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("execute"), []byte("overlaywasm"), []byte("deleteget")})
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Payload).Should(BeEmpty())
+	})
+	It("should read a key from the ledger once per invocation", func() {
+		counting := &countingStub{MockStub: stub}
+		r := Resolver{chaincodeName: "overlaywasm", stub: counting, state: newStateOverlay(counting)}
+		result, err := runWASM(lifeEngine{}, overlayModule, "sizeget", 0, defaultRuntimeLimits(), &r)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(result).Should(Equal(int64(2)))
+		Expect(counting.reads).Should(Equal(1))
+	})
+})
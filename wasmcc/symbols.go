@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+
+	"github.com/perlin-network/life/exec"
+)
+
+// Index name for symbol tables of installed wasm chaincodes
+var chaincodeSymbolsIndex = "chaincodeSymbols"
+
+// Section ids used while walking a wasm binary
+const (
+	wasmCustomSectionID = 0
+	wasmImportSectionID = 2
+	wasmExportSectionID = 7
+	wasmCodeSectionID   = 10
+)
+
+var errMalformedWasm = errors.New("malformed wasm binary")
+
+// wasmSection is a raw section of a wasm binary. For custom sections Name holds the
+// section name and Payload starts right after it.
+type wasmSection struct {
+	ID      byte
+	Name    string
+	Payload []byte
+	Offset  int
+}
+
+// sourceLocation points to a line in the source the wasm chaincode was compiled from.
+type sourceLocation struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// moduleSymbols maps wasm function indices to the names and source locations found in the
+// `name` custom section and in the DWARF `.debug_line` section of a module.
+type moduleSymbols struct {
+	Functions map[int]string         `json:"functions,omitempty"`
+	Locations map[int]sourceLocation `json:"locations,omitempty"`
+}
+
+// readWasmSections splits a wasm binary into its sections without decoding them.
+func readWasmSections(code []byte) ([]wasmSection, error) {
+	if len(code) < 8 || !bytes.Equal(code[:4], []byte("\x00asm")) {
+		return nil, errMalformedWasm
+	}
+
+	var sections []wasmSection
+	pos := 8
+	for pos < len(code) {
+		id := code[pos]
+		size, n := binary.Uvarint(code[pos+1:])
+		if n <= 0 || uint64(len(code)-pos-1-n) < size {
+			return nil, errMalformedWasm
+		}
+		start := pos + 1 + n
+		end := start + int(size)
+
+		section := wasmSection{ID: id, Payload: code[start:end], Offset: start}
+		if id == wasmCustomSectionID {
+			r := &wasmReader{buf: section.Payload}
+			section.Name = r.name()
+			if r.err != nil {
+				return nil, r.err
+			}
+			section.Payload = section.Payload[r.pos:]
+			section.Offset += r.pos
+		}
+		sections = append(sections, section)
+		pos = end
+	}
+	return sections, nil
+}
+
+// wasmReader decodes the LEB128 encoded primitives used inside wasm sections.
+// The first decoding error is kept in err and turns all further reads into no-ops.
+type wasmReader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (r *wasmReader) eof() bool {
+	return r.err != nil || r.pos >= len(r.buf)
+}
+
+func (r *wasmReader) byte() byte {
+	if r.eof() {
+		r.err = errMalformedWasm
+		return 0
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *wasmReader) u32() uint32 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 || v > 0xffffffff {
+		r.err = errMalformedWasm
+		return 0
+	}
+	r.pos += n
+	return uint32(v)
+}
+
+func (r *wasmReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.buf)-r.pos < n {
+		r.err = errMalformedWasm
+		return nil
+	}
+	b := r.buf[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *wasmReader) name() string {
+	return string(r.bytes(int(r.u32())))
+}
+
+func (r *wasmReader) limits() (uint32, uint32, bool) {
+	flags := r.u32()
+	min := r.u32()
+	if flags&1 == 0 {
+		return min, 0, false
+	}
+	return min, r.u32(), true
+}
+
+// parseModuleSymbols extracts function names and, when the module carries DWARF debug
+// information, the source location of every function body.
+func parseModuleSymbols(code []byte) (*moduleSymbols, error) {
+	sections, err := readWasmSections(code)
+	if err != nil {
+		return nil, err
+	}
+
+	symbols := &moduleSymbols{
+		Functions: map[int]string{},
+		Locations: map[int]sourceLocation{},
+	}
+
+	numImports := 0
+	debugSections := map[string][]byte{}
+	var codeSection *wasmSection
+
+	for i := range sections {
+		section := &sections[i]
+		switch section.ID {
+		case wasmImportSectionID:
+			numImports, err = countFunctionImports(section.Payload)
+			if err != nil {
+				return nil, err
+			}
+		case wasmCodeSectionID:
+			codeSection = section
+		case wasmCustomSectionID:
+			if section.Name == "name" {
+				parseNameSection(section.Payload, symbols.Functions)
+			} else if strings.HasPrefix(section.Name, ".debug_") {
+				debugSections[section.Name] = section.Payload
+			}
+		}
+	}
+
+	if codeSection != nil && debugSections[".debug_line"] != nil {
+		lines, err := readDWARFLines(debugSections)
+		if err != nil {
+			// Debug information is best effort, function names are still useful on their own
+			logger.Warningf("Unable to read DWARF line table: %s", err)
+		} else {
+			locateFunctions(codeSection.Payload, numImports, lines, symbols.Locations)
+		}
+	}
+
+	return symbols, nil
+}
+
+// countFunctionImports returns how many entries of the import section are functions.
+// Imported functions come first in the function index space.
+func countFunctionImports(payload []byte) (int, error) {
+	r := &wasmReader{buf: payload}
+	count := int(r.u32())
+	functions := 0
+	for i := 0; i < count && r.err == nil; i++ {
+		r.name()
+		r.name()
+		switch r.byte() {
+		case 0: // function
+			r.u32()
+			functions++
+		case 1: // table
+			r.byte()
+			r.limits()
+		case 2: // memory
+			r.limits()
+		case 3: // global
+			r.byte()
+			r.byte()
+		default:
+			return 0, errMalformedWasm
+		}
+	}
+	return functions, r.err
+}
+
+// moduleFunctionExports returns the sorted names of the functions exported by a module.
+func moduleFunctionExports(code []byte) ([]string, error) {
+	sections, err := readWasmSections(code)
+	if err != nil {
+		return nil, err
+	}
+
+	var exports []string
+	for _, section := range sections {
+		if section.ID != wasmExportSectionID {
+			continue
+		}
+		r := &wasmReader{buf: section.Payload}
+		count := int(r.u32())
+		for i := 0; i < count && r.err == nil; i++ {
+			name := r.name()
+			kind := r.byte()
+			r.u32()
+			if kind == 0 { // function
+				exports = append(exports, name)
+			}
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+	sort.Strings(exports)
+	return exports, nil
+}
+
+// exportsFunction reports whether a module exports a function.
+func exportsFunction(code []byte, function string) (bool, error) {
+	exports, err := moduleFunctionExports(code)
+	if err != nil {
+		return false, err
+	}
+	i := sort.SearchStrings(exports, function)
+	return i < len(exports) && exports[i] == function, nil
+}
+
+// parseNameSection reads the function names subsection of the `name` custom section.
+// A malformed section is ignored past the point where decoding failed.
+func parseNameSection(payload []byte, functions map[int]string) {
+	r := &wasmReader{buf: payload}
+	for !r.eof() {
+		id := r.byte()
+		sub := &wasmReader{buf: r.bytes(int(r.u32()))}
+		if id != 1 {
+			continue
+		}
+		count := int(sub.u32())
+		for i := 0; i < count && sub.err == nil; i++ {
+			index := int(sub.u32())
+			name := sub.name()
+			if sub.err == nil {
+				functions[index] = name
+			}
+		}
+	}
+}
+
+type lineEntry struct {
+	address uint64
+	file    string
+	line    int
+}
+
+// readDWARFLines flattens the line tables of all compilation units found in the
+// module's DWARF sections. Addresses are offsets into the code section payload.
+func readDWARFLines(sections map[string][]byte) ([]lineEntry, error) {
+	d, err := dwarf.New(
+		sections[".debug_abbrev"],
+		sections[".debug_aranges"],
+		sections[".debug_frame"],
+		sections[".debug_info"],
+		sections[".debug_line"],
+		sections[".debug_pubnames"],
+		sections[".debug_ranges"],
+		sections[".debug_str"],
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []lineEntry
+	reader := d.Reader()
+	for {
+		entry, err := reader.Next()
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			break
+		}
+		if entry.Tag != dwarf.TagCompileUnit {
+			reader.SkipChildren()
+			continue
+		}
+
+		lr, err := d.LineReader(entry)
+		reader.SkipChildren()
+		if err != nil {
+			return nil, err
+		}
+		if lr == nil {
+			continue
+		}
+
+		var le dwarf.LineEntry
+		for {
+			if err := lr.Next(&le); err != nil {
+				break
+			}
+			if le.EndSequence || le.File == nil {
+				continue
+			}
+			lines = append(lines, lineEntry{le.Address, le.File.Name, le.Line})
+		}
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].address < lines[j].address })
+	return lines, nil
+}
+
+// locateFunctions assigns every function body the first line table entry that falls
+// inside it.
+func locateFunctions(payload []byte, numImports int, lines []lineEntry, locations map[int]sourceLocation) {
+	r := &wasmReader{buf: payload}
+	count := int(r.u32())
+	for i := 0; i < count && r.err == nil; i++ {
+		start := uint64(r.pos)
+		r.bytes(int(r.u32()))
+		end := uint64(r.pos)
+
+		j := sort.Search(len(lines), func(k int) bool { return lines[k].address >= start })
+		if j < len(lines) && lines[j].address < end {
+			locations[numImports+i] = sourceLocation{File: lines[j].file, Line: lines[j].line}
+		}
+	}
+}
+
+// describe returns a readable reference to a function, falling back to its index.
+func (s *moduleSymbols) describe(functionID int) string {
+	name := fmt.Sprintf("func[%d]", functionID)
+	if s == nil {
+		return name
+	}
+	if n, ok := s.Functions[functionID]; ok {
+		name = n
+	}
+	if loc, ok := s.Locations[functionID]; ok {
+		name = fmt.Sprintf("%s (%s:%d)", name, path.Clean(loc.File), loc.Line)
+	}
+	return name
+}
+
+// stackTrace renders the call stack of a trapped vm using the module symbols.
+func stackTrace(vm *exec.VirtualMachine, symbols *moduleSymbols) string {
+	var b strings.Builder
+	b.WriteString("wasm stack trace:")
+	for i := vm.CurrentFrame; i >= 0 && i < len(vm.CallStack); i-- {
+		fmt.Fprintf(&b, "\n  #%d %s", vm.CurrentFrame-i, symbols.describe(vm.CallStack[i].FunctionID))
+	}
+	return b.String()
+}
+
+// storeSymbols keeps the symbol table of a wasm chaincode next to its code.
+func storeSymbols(stub shim.ChaincodeStubInterface, chaincodeName string, symbols *moduleSymbols) error {
+	symbolsBytes, err := json.Marshal(symbols)
+	if err != nil {
+		return err
+	}
+	key, err := stub.CreateCompositeKey(chaincodeSymbolsIndex, []string{chaincodeName})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, symbolsBytes)
+}
+
+// loadSymbols returns the symbol table stored for a wasm chaincode. Chaincodes created
+// before symbol tables were stored get theirs parsed from the module bytes.
+func loadSymbols(stub shim.ChaincodeStubInterface, chaincodeName string, code []byte) *moduleSymbols {
+	key, err := stub.CreateCompositeKey(chaincodeSymbolsIndex, []string{chaincodeName})
+	if err == nil {
+		symbolsBytes, err := stub.GetState(key)
+		if err == nil && symbolsBytes != nil {
+			symbols := &moduleSymbols{}
+			if err := json.Unmarshal(symbolsBytes, symbols); err == nil {
+				return symbols
+			}
+		}
+	}
+
+	symbols, err := parseModuleSymbols(code)
+	if err != nil {
+		logger.Warningf("Unable to read symbols of %s: %s", chaincodeName, err)
+		return nil
+	}
+	return symbols
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tests for wasm chaincode symbols", func() {
+
+	status200 := int32(200)
+	status500 := int32(500)
+
+	trappingModule := buildTestModule(nil, []testFunc{
+		{name: "init", params: []byte{i64}, results: []byte{i64}, body: returnI64(0)},
+		{name: "fail", params: []byte{i64}, results: []byte{i64}, body: []byte{opI64Const, 0, opCall, 2}},
+		{name: "abort", params: []byte{i64}, results: []byte{i64}, body: []byte{opUnreachable}},
+	}, nil)
+
+	Describe("Parsing the name section", func() {
+		It("should name the exported functions of the sample chaincode", func() {
+			symbols, err := parseModuleSymbols(ReadAssetTransferWASM())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(symbols.Functions).Should(ContainElement("init"))
+			Expect(symbols.Functions).Should(ContainElement("query"))
+		})
+		It("should use function indices for unnamed functions", func() {
+			symbols, err := parseModuleSymbols(trappingModule)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(symbols.describe(2)).Should(Equal("abort"))
+			Expect(symbols.describe(7)).Should(Equal("func[7]"))
+		})
+		It("should reject data which is not a wasm binary", func() {
+			_, err := parseModuleSymbols([]byte("not wasm"))
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+
+	Describe("Trapping wasm chaincode", func() {
+		stub := shim.NewMockStub("symbolsStub", new(WASMChaincode))
+		stub.MockInit("000", nil)
+
+		It("should be created", func() {
+			result := stub.MockInvoke("000",
+				[][]byte{[]byte("create"), []byte("trapwasm"), trappingModule})
+			Expect(result.Status).Should(Equal(status200))
+		})
+		It("should report the trap without stack trace", func() {
+			result := stub.MockInvoke("000",
+				[][]byte{[]byte("execute"), []byte("trapwasm"), []byte("fail")})
+			Expect(result.Status).Should(Equal(status500))
+			Expect(result.Message).Should(ContainSubstring("unreachable"))
+			Expect(result.Message).ShouldNot(ContainSubstring("stack trace"))
+		})
+		It("should return the symbolized stack trace in dev mode", func() {
+			devMode = true
+			defer func() { devMode = false }()
+
+			result := stub.MockInvoke("000",
+				[][]byte{[]byte("execute"), []byte("trapwasm"), []byte("fail")})
+			Expect(result.Status).Should(Equal(status500))
+			Expect(result.Message).Should(ContainSubstring("#0 abort\n  #1 fail"))
+		})
+	})
+})
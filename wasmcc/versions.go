@@ -0,0 +1,501 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Index name for version metadata of installed wasm chaincodes
+var chaincodeMetadataIndex = "chaincodeMetadata"
+
+// Index name for the code of every version of installed wasm chaincodes, kept for rollback
+var chaincodeHistoryIndex = "chaincodeHistory"
+
+// migrateFunction is called by upgrade, if the new version of a chaincode exports it, to
+// migrate the state left by the previous version.
+const migrateFunction = "migrate"
+
+// chaincodeMetadata describes the active version of a wasm chaincode. After a rollback the
+// active version is older than the latest version.
+type chaincodeMetadata struct {
+	Version          uint64 `json:"version"`
+	LatestVersion    uint64 `json:"latestVersion"`
+	CodeHash         string `json:"codeHash"`
+	PreviousCodeHash string `json:"previousCodeHash,omitempty"`
+	// Paused chaincodes reject execute until resumed
+	Paused bool `json:"paused,omitempty"`
+	// Creator and transactions which created the chaincode and last upgraded or rolled it
+	// back, empty for chaincodes created before they were recorded
+	CreatorMSP  string `json:"creatorMsp,omitempty"`
+	CreateTxID  string `json:"createTxId,omitempty"`
+	CreatedAt   string `json:"createdAt,omitempty"`
+	UpgradeTxID string `json:"upgradeTxId,omitempty"`
+	UpgradedAt  string `json:"upgradedAt,omitempty"`
+	// Size and exported functions of the code, so listing chaincodes does not read their
+	// modules. Size is 0 for chaincodes stored before they were recorded
+	Size      int      `json:"size,omitempty"`
+	Functions []string `json:"functions,omitempty"`
+}
+
+// codeHash identifies the code of a wasm chaincode.
+func codeHash(code []byte) string {
+	sum := sha256.Sum256(code)
+	return hex.EncodeToString(sum[:])
+}
+
+// transactionTime returns the timestamp of the transaction, and formatted as RFC 3339 in UTC.
+func transactionTime(stub shim.ChaincodeStubInterface) (time.Time, string, error) {
+	timestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	at := time.Unix(timestamp.Seconds, int64(timestamp.Nanos)).UTC()
+	return at, at.Format(time.RFC3339Nano), nil
+}
+
+func chaincodeMetadataKey(stub shim.ChaincodeStubInterface, chaincodeName string) (string, error) {
+	return stub.CreateCompositeKey(chaincodeMetadataIndex, []string{chaincodeName})
+}
+
+// loadMetadata returns the metadata of an installed chaincode. Chaincodes created before
+// metadata was stored are version 1 of their code.
+func loadMetadata(stub shim.ChaincodeStubInterface, chaincodeName string, code []byte) (*chaincodeMetadata, error) {
+	key, err := chaincodeMetadataKey(stub, chaincodeName)
+	if err != nil {
+		return nil, err
+	}
+	metadataBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if metadataBytes == nil {
+		return &chaincodeMetadata{Version: 1, LatestVersion: 1, CodeHash: codeHash(code)}, nil
+	}
+
+	metadata := &chaincodeMetadata{}
+	if err := json.Unmarshal(metadataBytes, metadata); err != nil {
+		return nil, err
+	}
+	if metadata.LatestVersion < metadata.Version {
+		metadata.LatestVersion = metadata.Version
+	}
+	return metadata, nil
+}
+
+func chaincodeVersionKey(stub shim.ChaincodeStubInterface, chaincodeName string, version uint64) (string, error) {
+	return stub.CreateCompositeKey(chaincodeHistoryIndex, []string{chaincodeName, strconv.FormatUint(version, 10)})
+}
+
+// loadVersion returns the code of a version of a chaincode, nil if there is no such version.
+func loadVersion(stub shim.ChaincodeStubInterface, chaincodeName string, version uint64) ([]byte, error) {
+	key, err := chaincodeVersionKey(stub, chaincodeName, version)
+	if err != nil {
+		return nil, err
+	}
+	return newModuleStore(stub).get(key)
+}
+
+// chaincodeRef is a chaincode reference of execute, name[@version|:tag][#sha256]. A chaincode
+// may be pinned to a version or a tag, and to the hash of the code the client expects to run.
+type chaincodeRef struct {
+	name string
+	// version is 0 for the active version
+	version uint64
+	// tag is empty unless the reference is pinned to a tag
+	tag string
+	// codeHash is empty if the code is not pinned
+	codeHash string
+}
+
+func parseChaincodeRef(ref string) (chaincodeRef, error) {
+	parsed := chaincodeRef{name: ref}
+	if i := strings.LastIndex(parsed.name, "#"); i >= 0 {
+		parsed.codeHash = strings.ToLower(parsed.name[i+1:])
+		parsed.name = parsed.name[:i]
+		if hash, err := hex.DecodeString(parsed.codeHash); err != nil || len(hash) != sha256.Size {
+			return chaincodeRef{}, fmt.Errorf("invalid code hash in %s, expecting hex encoded sha256", ref)
+		}
+	}
+	if i := strings.LastIndex(parsed.name, "@"); i >= 0 {
+		version, err := strconv.ParseUint(parsed.name[i+1:], 10, 64)
+		if err != nil || version == 0 {
+			return chaincodeRef{}, fmt.Errorf("invalid chaincode version in %s", ref)
+		}
+		parsed.name = parsed.name[:i]
+		parsed.version = version
+	}
+	if i := strings.Index(parsed.name, ":"); i >= 0 {
+		if parsed.version != 0 || i == len(parsed.name)-1 {
+			return chaincodeRef{}, fmt.Errorf("invalid chaincode tag in %s", ref)
+		}
+		parsed.tag = parsed.name[i+1:]
+		parsed.name = parsed.name[:i]
+	}
+	return parsed, nil
+}
+
+// checkChaincodeName rejects names which cannot be referenced by a chaincode reference:
+// empty names and names containing the separators of versions, tags and code hashes.
+func checkChaincodeName(name string) error {
+	if name == "" || strings.ContainsAny(name, "@#:") {
+		return fmt.Errorf("invalid chaincode name %q, expecting a name without @, # and :", name)
+	}
+	return nil
+}
+
+// checkCodeHash rejects code which differs from the code the reference is pinned to.
+func (ref chaincodeRef) checkCodeHash(code []byte) error {
+	if ref.codeHash == "" {
+		return nil
+	}
+	if actual := codeHash(code); actual != ref.codeHash {
+		return fmt.Errorf(CodeHashMismatch, ref.name, actual, ref.codeHash)
+	}
+	return nil
+}
+
+// loadChaincodeCode returns the code of the active version of a chaincode, or of the version
+// or tag the reference is pinned to, and a response if there is no such code.
+func loadChaincodeCode(stub shim.ChaincodeStubInterface, ref chaincodeRef) ([]byte, *pb.Response) {
+	var key string
+	var err error
+	if ref.version != 0 {
+		key, err = chaincodeVersionKey(stub, ref.name, ref.version)
+	} else if ref.tag != "" {
+		key, err = chaincodeTagKey(stub, ref.name, ref.tag)
+	} else {
+		key, err = stub.CreateCompositeKey(chaincodeStoreIndex, []string{ref.name})
+	}
+
+	var code []byte
+	if err == nil {
+		code, err = newModuleStore(stub).get(key)
+	}
+	// chaincodes created before versions were kept have no history of their active version
+	if err == nil && code == nil && ref.version != 0 {
+		code, err = activeVersionCode(stub, ref.name, ref.version)
+	}
+	if err != nil {
+		response := shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+		return nil, &response
+	}
+	if code == nil {
+		jsonResp := "{\"Error\":\"No Chaincode for " + ref.name + "\"}"
+		if ref.version != 0 {
+			jsonResp = fmt.Sprintf("{\"Error\":\"No version %d of Chaincode %s\"}", ref.version, ref.name)
+		} else if ref.tag != "" {
+			jsonResp = "{\"Error\":\"No tag " + ref.tag + " of Chaincode " + ref.name + "\"}"
+		}
+		response := shim.Error(jsonResp)
+		return nil, &response
+	}
+	return code, nil
+}
+
+// activeVersionCode returns the code of the active version of a chaincode if it is the given
+// version, nil otherwise.
+func activeVersionCode(stub shim.ChaincodeStubInterface, chaincodeName string, version uint64) ([]byte, error) {
+	key, err := stub.CreateCompositeKey(chaincodeStoreIndex, []string{chaincodeName})
+	if err != nil {
+		return nil, err
+	}
+	code, err := newModuleStore(stub).get(key)
+	if err != nil || code == nil {
+		return nil, err
+	}
+	metadata, err := loadMetadata(stub, chaincodeName, code)
+	if err != nil || metadata.Version != version {
+		return nil, err
+	}
+	return code, nil
+}
+
+// storeChaincode makes a version of a chaincode the active one, pointing the name and the
+// version in the history of the chaincode to its code, and stores its symbol table and
+// metadata, completed with the size and exported functions of the code. Pending writes of
+// the module store are applied too.
+func storeChaincode(store *moduleStore, chaincodeName string, code []byte, symbols *moduleSymbols, metadata *chaincodeMetadata) error {
+	stub := store.stub
+	metadata.Size = len(code)
+	metadata.Functions = codeFunctions(chaincodeName, code)
+	ledgerChaincodeKey, err := stub.CreateCompositeKey(chaincodeStoreIndex, []string{chaincodeName})
+	if err != nil {
+		return err
+	}
+	if err := store.point(ledgerChaincodeKey, code); err != nil {
+		return err
+	}
+	versionKey, err := chaincodeVersionKey(stub, chaincodeName, metadata.Version)
+	if err != nil {
+		return err
+	}
+	if err := store.point(versionKey, code); err != nil {
+		return err
+	}
+	if err := store.flush(); err != nil {
+		return err
+	}
+	if err := storeSymbols(stub, chaincodeName, symbols); err != nil {
+		return err
+	}
+	return storeMetadata(stub, chaincodeName, metadata)
+}
+
+func storeMetadata(stub shim.ChaincodeStubInterface, chaincodeName string, metadata *chaincodeMetadata) error {
+	metadataBytes, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	key, err := chaincodeMetadataKey(stub, chaincodeName)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, metadataBytes)
+}
+
+// upgrade replaces the code of an installed chaincode with a new version. Receives chaincode
+// name, the new wasm chaincode and the parameters of its migrate function. The new version
+// is only stored if migrate, when exported, returns 0.
+func (t *WASMChaincode) upgrade(stub shim.ChaincodeStubInterface, args [][]byte) pb.Response {
+	return t.upgradeVersion(stub, args, false)
+}
+
+// upgradeVersion is upgrade, approved upgrades committed by the approval workflow skip the
+// owner check.
+func (t *WASMChaincode) upgradeVersion(stub shim.ChaincodeStubInterface, args [][]byte, approved bool) pb.Response {
+	logger.Infof("Upgrade function")
+	if len(args) < 2 {
+		return shim.Error("Incorrect number of arguments. Expecting atleast 2 arguments")
+	}
+
+	chaincodeName := string(args[0])
+	logger.Infof("Upgrading wasm chaincode: " + chaincodeName)
+
+	previousCode, errResponse := loadChaincodeCode(stub, chaincodeRef{name: chaincodeName})
+	if errResponse != nil {
+		return *errResponse
+	}
+	if !approved {
+		if errResponse := checkOwnerOrAdmin(stub, chaincodeName); errResponse != nil {
+			return *errResponse
+		}
+	}
+	previous, err := loadMetadata(stub, chaincodeName, previousCode)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+
+	code, err := decodeReceivedWASMChaincode(args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	config, err := loadChannelConfig(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	if err := config.checkModule(code); err != nil {
+		return shim.Error(err.Error())
+	}
+	symbols, err := parseModuleSymbols(code)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	migrates, err := exportsFunction(code, migrateFunction)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	gasSchedule, err := loadHostGasSchedule(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	limits, err := loadRuntimeLimits(stub, chaincodeName)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	engine, err := loadEngine(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(InvalidConfig, err.Error()))
+	}
+	if err := engine.validate(code); err != nil {
+		return executionError(err)
+	}
+	if err := limits.checkArgsSize(args[2:]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	r := Resolver{
+		chaincodeName: chaincodeName,
+		stub:          stub,
+		state:         newStateOverlay(stub),
+		args:          args[2:],
+		symbols:       symbols,
+		gasSchedule:   gasSchedule,
+	}
+
+	if migrates {
+		result, err := runWASM(engine, code, migrateFunction, len(args)-2, limits, &r)
+		if err != nil {
+			return withGasUsed(stub, executionError(err), r.gasUsed)
+		}
+
+		logger.Infof("Migrate Response:%d, gas used:%d\n", result, r.gasUsed)
+
+		if result != 0 {
+			return withGasUsed(stub, shim.Error("Chaincode migrate invocation failed"), r.gasUsed)
+		}
+		if !invocationSucceeded(result, &r) {
+			return withGasUsed(stub, invocationResponse(result, &r), r.gasUsed)
+		}
+		if err := r.state.flush(); err != nil {
+			return withGasUsed(stub, shim.Error(fmt.Sprintf(UnknownError, err.Error())), r.gasUsed)
+		}
+	}
+
+	// chaincodes created before versions were kept have no history of their first version
+	store := newModuleStore(stub)
+	previousVersion, err := loadVersion(stub, chaincodeName, previous.Version)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	if previousVersion == nil {
+		versionKey, err := chaincodeVersionKey(stub, chaincodeName, previous.Version)
+		if err == nil {
+			err = store.point(versionKey, previousCode)
+		}
+		if err != nil {
+			return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+		}
+	}
+
+	_, upgradedAt, err := transactionTime(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	metadata := &chaincodeMetadata{
+		Version:          previous.LatestVersion + 1,
+		LatestVersion:    previous.LatestVersion + 1,
+		CodeHash:         codeHash(code),
+		PreviousCodeHash: previous.CodeHash,
+		Paused:           previous.Paused,
+		CreatorMSP:       previous.CreatorMSP,
+		CreateTxID:       previous.CreateTxID,
+		CreatedAt:        previous.CreatedAt,
+		UpgradeTxID:      stub.GetTxID(),
+		UpgradedAt:       upgradedAt,
+	}
+	if err := storeChaincode(store, chaincodeName, code, symbols, metadata); err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+
+	metadataBytes, err := json.Marshal(metadata)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	return withGasUsed(stub, shim.Success(metadataBytes), r.gasUsed)
+}
+
+// rollback makes a version kept in the history of a chaincode the active one again. Receives
+// chaincode name and version. No migration runs, the state is left as the newer version
+// left it.
+func (t *WASMChaincode) rollback(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Infof("Rollback function")
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode name and version")
+	}
+
+	chaincodeName := args[0]
+	version, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil || version == 0 {
+		return shim.Error(fmt.Sprintf(InvalidConfig, "invalid chaincode version "+args[1]))
+	}
+
+	activeCode, errResponse := loadChaincodeCode(stub, chaincodeRef{name: chaincodeName})
+	if errResponse != nil {
+		return *errResponse
+	}
+	if errResponse := checkOwnerOrAdmin(stub, chaincodeName); errResponse != nil {
+		return *errResponse
+	}
+	active, err := loadMetadata(stub, chaincodeName, activeCode)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	code, errResponse := loadChaincodeCode(stub, chaincodeRef{name: chaincodeName, version: version})
+	if errResponse != nil {
+		return *errResponse
+	}
+	symbols, err := parseModuleSymbols(code)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	_, rolledBackAt, err := transactionTime(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	metadata := &chaincodeMetadata{
+		Version:          version,
+		LatestVersion:    active.LatestVersion,
+		CodeHash:         codeHash(code),
+		PreviousCodeHash: active.CodeHash,
+		Paused:           active.Paused,
+		CreatorMSP:       active.CreatorMSP,
+		CreateTxID:       active.CreateTxID,
+		CreatedAt:        active.CreatedAt,
+		UpgradeTxID:      stub.GetTxID(),
+		UpgradedAt:       rolledBackAt,
+	}
+	if err := storeChaincode(newModuleStore(stub), chaincodeName, code, symbols, metadata); err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+
+	metadataBytes, err := json.Marshal(metadata)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	return shim.Success(metadataBytes)
+}
+
+// downloadModule returns the stored bytes of a version of a chaincode, so the deployed code
+// can be compared with a reproducible build. Receives chaincode name, version and an
+// optional encoding, raw by default, hex or base64.
+func (t *WASMChaincode) downloadModule(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode name, version and optional encoding")
+	}
+
+	chaincodeName := args[0]
+	version, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil || version == 0 {
+		return shim.Error(fmt.Sprintf(InvalidConfig, "invalid chaincode version "+args[1]))
+	}
+	encoding := "raw"
+	if len(args) == 3 && args[2] != "" {
+		encoding = args[2]
+	}
+
+	code, errResponse := loadChaincodeCode(stub, chaincodeRef{name: chaincodeName, version: version})
+	if errResponse != nil {
+		return *errResponse
+	}
+
+	switch encoding {
+	case "raw":
+		return shim.Success(code)
+	case "hex":
+		return shim.Success([]byte(hex.EncodeToString(code)))
+	case "base64":
+		return shim.Success([]byte(base64.StdEncoding.EncodeToString(code)))
+	}
+	return shim.Error(fmt.Sprintf(InvalidConfig, "unknown encoding "+encoding+", expecting raw, hex or base64"))
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tests for chaincode versions", func() {
+
+	status200 := int32(200)
+	status500 := int32(500)
+
+	const opI32WrapI64 = 0xa7
+	imports := []testImport{
+		{field: "__get_parameter", params: []byte{i32, i32}, results: []byte{i64}},
+		{field: "__put_state", params: []byte{i32, i32, i32, i32}, results: []byte{i64}},
+	}
+	const getParameter, putState = 0, 1
+
+	// putParameter stores the first parameter under the key "k"
+	putParameter := []byte{opI32Const, 0, opI32Const, 1, opI32Const, 16,
+		opI32Const, 0, opI32Const, 16, opCall, getParameter, opI32WrapI64, opCall, putState}
+	versionModule := func(version int64, migrate []byte) []byte {
+		funcs := []testFunc{
+			{name: "init", params: []byte{i64}, results: []byte{i64}, body: returnI64(0)},
+			{name: "version", params: []byte{i64}, results: []byte{i64}, body: returnI64(version)},
+		}
+		if migrate != nil {
+			funcs = append(funcs, testFunc{name: "migrate", params: []byte{i64}, results: []byte{i64}, body: migrate})
+		}
+		return buildTestModule(imports, funcs, []byte("k"))
+	}
+	v1 := versionModule(1, nil)
+	v2 := versionModule(2, putParameter)
+	failing := versionModule(3, concat(putParameter, []byte{opDrop}, returnI64(-1)))
+	v3 := versionModule(3, nil)
+
+	stub := shim.NewMockStub("versionsStub", new(WASMChaincode))
+	stub.MockInit("000", nil)
+
+	versionOf := func(ref string) string {
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("execute"), []byte(ref), []byte("version")})
+		Expect(result.Status).Should(Equal(status200), result.Message)
+		return string(result.Payload)
+	}
+	version := func() string {
+		return versionOf("versionwasm")
+	}
+
+	It("should not create a chaincode twice", func() {
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("create"), []byte("versionwasm"), v1})
+		Expect(result.Status).Should(Equal(status200))
+
+		result = stub.MockInvoke("000",
+			[][]byte{[]byte("create"), []byte("versionwasm"), v2})
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(Equal(ChaincodeExists))
+		Expect(version()).Should(Equal("1"))
+	})
+	It("should not create chaincodes with names which cannot be referenced", func() {
+		for _, name := range []string{"", "name@1", "name:tag", "name#hash"} {
+			result := stub.MockInvoke("000",
+				[][]byte{[]byte("create"), []byte(name), v1})
+			Expect(result.Status).Should(Equal(status500))
+			Expect(result.Message).Should(ContainSubstring("invalid chaincode name"))
+		}
+	})
+	It("should not upgrade chaincodes which are not installed", func() {
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("upgrade"), []byte("missingwasm"), v2})
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(ContainSubstring("No Chaincode for missingwasm"))
+	})
+	It("should upgrade and migrate with the given parameters", func() {
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("upgrade"), []byte("versionwasm"), v2, []byte("migrated")})
+		Expect(result.Status).Should(Equal(status200))
+
+		metadata := chaincodeMetadata{}
+		Expect(json.Unmarshal(result.Payload, &metadata)).Should(Succeed())
+		Expect(metadata.UpgradedAt).ShouldNot(Equal(metadata.CreatedAt))
+		Expect(metadata).Should(Equal(chaincodeMetadata{Version: 2, LatestVersion: 2, CodeHash: codeHash(v2), PreviousCodeHash: codeHash(v1),
+			CreateTxID: "000", CreatedAt: metadata.CreatedAt, UpgradeTxID: "000", UpgradedAt: metadata.UpgradedAt,
+			Size: len(v2), Functions: []string{"init", "migrate", "version"}}))
+		Expect(string(stub.State["versionwasm_k"])).Should(Equal("migrated"))
+		Expect(version()).Should(Equal("2"))
+	})
+	It("should keep the previous version if migrate fails", func() {
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("upgrade"), []byte("versionwasm"), failing, []byte("failed")})
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(Equal("Chaincode migrate invocation failed"))
+		Expect(string(stub.State["versionwasm_k"])).Should(Equal("migrated"))
+		Expect(version()).Should(Equal("2"))
+	})
+	It("should upgrade versions without migrate", func() {
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("upgrade"), []byte("versionwasm"), v3})
+		Expect(result.Status).Should(Equal(status200))
+
+		metadata := chaincodeMetadata{}
+		Expect(json.Unmarshal(result.Payload, &metadata)).Should(Succeed())
+		Expect(metadata.Version).Should(Equal(uint64(3)))
+		Expect(metadata.PreviousCodeHash).Should(Equal(codeHash(v2)))
+		Expect(version()).Should(Equal("3"))
+	})
+	It("should execute pinned versions", func() {
+		Expect(versionOf("versionwasm@1")).Should(Equal("1"))
+		Expect(versionOf("versionwasm@2")).Should(Equal("2"))
+		Expect(version()).Should(Equal("3"))
+
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("execute"), []byte("versionwasm@4"), []byte("version")})
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(ContainSubstring("No version 4 of Chaincode versionwasm"))
+		result = stub.MockInvoke("000",
+			[][]byte{[]byte("execute"), []byte("versionwasm@latest"), []byte("version")})
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(ContainSubstring("invalid chaincode version"))
+	})
+	It("should roll back to a previous version", func() {
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("rollback"), []byte("versionwasm"), []byte("1")})
+		Expect(result.Status).Should(Equal(status200))
+
+		metadata := chaincodeMetadata{}
+		Expect(json.Unmarshal(result.Payload, &metadata)).Should(Succeed())
+		Expect(metadata).Should(Equal(chaincodeMetadata{Version: 1, LatestVersion: 3, CodeHash: codeHash(v1), PreviousCodeHash: codeHash(v3),
+			CreateTxID: "000", CreatedAt: metadata.CreatedAt, UpgradeTxID: "000", UpgradedAt: metadata.UpgradedAt,
+			Size: len(v1), Functions: []string{"init", "version"}}))
+		Expect(version()).Should(Equal("1"))
+
+		result = stub.MockInvoke("000",
+			[][]byte{[]byte("rollback"), []byte("versionwasm"), []byte("5")})
+		Expect(result.Status).Should(Equal(status500))
+		Expect(version()).Should(Equal("1"))
+	})
+	It("should number upgrades after the latest version", func() {
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("upgrade"), []byte("versionwasm"), v2, []byte("again")})
+		Expect(result.Status).Should(Equal(status200))
+
+		metadata := chaincodeMetadata{}
+		Expect(json.Unmarshal(result.Payload, &metadata)).Should(Succeed())
+		Expect(metadata.Version).Should(Equal(uint64(4)))
+		Expect(metadata.PreviousCodeHash).Should(Equal(codeHash(v1)))
+		Expect(versionOf("versionwasm@3")).Should(Equal("3"))
+	})
+	It("should download the stored module of a version", func() {
+		download := func(args ...string) (int32, []byte) {
+			rawArgs := [][]byte{[]byte("downloadModule"), []byte("versionwasm")}
+			for _, arg := range args {
+				rawArgs = append(rawArgs, []byte(arg))
+			}
+			result := stub.MockInvoke("000", rawArgs)
+			return result.Status, result.Payload
+		}
+
+		status, payload := download("1")
+		Expect(status).Should(Equal(status200))
+		Expect(payload).Should(Equal(v1))
+		status, payload = download("2", "hex")
+		Expect(status).Should(Equal(status200))
+		Expect(string(payload)).Should(Equal(hex.EncodeToString(v2)))
+		status, payload = download("3", "base64")
+		Expect(status).Should(Equal(status200))
+		Expect(string(payload)).Should(Equal(base64.StdEncoding.EncodeToString(v3)))
+
+		status, _ = download("5")
+		Expect(status).Should(Equal(status500))
+		status, _ = download("0")
+		Expect(status).Should(Equal(status500))
+		status, _ = download("1", "gzip")
+		Expect(status).Should(Equal(status500))
+	})
+	It("should download the module of chaincodes created before versions were kept", func() {
+		stub.MockTransactionStart("legacy")
+		key, _ := stub.CreateCompositeKey(chaincodeStoreIndex, []string{"legacywasm"})
+		Expect(stub.PutState(key, v1)).Should(Succeed())
+		stub.MockTransactionEnd("legacy")
+
+		result := stub.MockInvoke("000", [][]byte{[]byte("downloadModule"), []byte("legacywasm"), []byte("1")})
+		Expect(result.Status).Should(Equal(status200), result.Message)
+		Expect(result.Payload).Should(Equal(v1))
+		Expect(versionOf("legacywasm@1")).Should(Equal("1"))
+
+		result = stub.MockInvoke("000", [][]byte{[]byte("downloadModule"), []byte("legacywasm"), []byte("2")})
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(ContainSubstring("No version 2 of Chaincode legacywasm"))
+	})
+
+	Describe("Code hash pinning", func() {
+		It("should execute code with the expected hash", func() {
+			Expect(versionOf("versionwasm#" + codeHash(v2))).Should(Equal("2"))
+			Expect(versionOf("versionwasm@1#" + strings.ToUpper(codeHash(v1)))).Should(Equal("1"))
+		})
+		It("should reject code which differs from the expected hash", func() {
+			result := stub.MockInvoke("000",
+				[][]byte{[]byte("execute"), []byte("versionwasm#" + codeHash(v1)), []byte("version")})
+			Expect(result.Status).Should(Equal(status500))
+			Expect(result.Message).Should(ContainSubstring(`"code":407`))
+			Expect(result.Message).Should(ContainSubstring(codeHash(v2)))
+		})
+		It("should reject malformed hashes", func() {
+			result := stub.MockInvoke("000",
+				[][]byte{[]byte("execute"), []byte("versionwasm#abc"), []byte("version")})
+			Expect(result.Status).Should(Equal(status500))
+			Expect(result.Message).Should(ContainSubstring("invalid code hash"))
+		})
+	})
+})
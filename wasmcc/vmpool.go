@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/perlin-network/life/exec"
+)
+
+// moduleInitializer is exported by reactor modules, e.g. modules built by Rust or clang for
+// wasi reactors, to run global constructors before any other export is called.
+const moduleInitializer = "_initialize"
+
+// vmPoolKey identifies vms which are interchangeable once reset: vms of the same compiled
+// module with the same limits.
+type vmPoolKey struct {
+	module moduleCacheKey
+	config exec.VMConfig
+}
+
+var (
+	vmPoolsLock sync.Mutex
+	vmPools     = make(map[vmPoolKey]*vmPool)
+)
+
+// vmPool keeps idle vms of a module for reuse, so an invocation does not have to allocate
+// and initialize the memory of a new vm. Idle vms are released by the garbage collector.
+type vmPool struct {
+	module *exec.Module
+	config exec.VMConfig
+	idle   sync.Pool
+
+	// state and memory of a vm which never ran, every vm is reset to them before reuse
+	state  []byte
+	memory []byte
+}
+
+// vmPoolFor returns the pool of vms of a compiled module with the given limits. The
+// initializer of reactor modules runs once when the pool is created, and every vm of the
+// pool starts from the state it left. Pools are keyed by the hash of the code, so a new
+// version of a chaincode is initialized again.
+func vmPoolFor(key moduleCacheKey, module *exec.Module, config exec.VMConfig) (*vmPool, error) {
+	vmPoolsLock.Lock()
+	defer vmPoolsLock.Unlock()
+
+	poolKey := vmPoolKey{module: key, config: config}
+	if pool, ok := vmPools[poolKey]; ok {
+		return pool, nil
+	}
+
+	pool := &vmPool{module: module, config: config}
+	vm := pool.newVM()
+	if err := initializeVM(vm); err != nil {
+		return nil, err
+	}
+	snapshot := vm.ReadSnapshot()
+	pool.state = snapshot.State
+	pool.memory = snapshot.Memory
+	vmPools[poolKey] = pool
+	return pool, nil
+}
+
+// initializeVM runs the initializer of a reactor module. It runs outside of any transaction,
+// so it may not call host functions, and its gas is not charged to invocations.
+func initializeVM(vm *exec.VirtualMachine) (retErr error) {
+	entryID, ok := vm.GetFunctionExport(moduleInitializer)
+	if !ok {
+		return nil
+	}
+
+	//life panics on invalid invocations, e.g. an initializer taking parameters
+	defer func() {
+		if err := recover(); err != nil {
+			retErr = fmt.Errorf("%s failed: %v", moduleInitializer, err)
+		}
+	}()
+
+	vm.ImportResolver = initializerResolver{}
+	if _, err := vm.Run(entryID); err != nil {
+		return fmt.Errorf("%s failed: %s", moduleInitializer, gasError(err, vm.Config.GasLimit))
+	}
+	vm.ImportResolver = nil
+	vm.Gas = 0
+	return nil
+}
+
+// initializerResolver resolves the imports of a module running its initializer.
+type initializerResolver struct{}
+
+func (initializerResolver) ResolveFunc(module, field string) exec.FunctionImport {
+	return func(vm *exec.VirtualMachine) int64 {
+		panic(errors.New("host functions cannot be called by " + moduleInitializer))
+	}
+}
+
+func (initializerResolver) ResolveGlobal(module, field string) int64 {
+	return (&Resolver{}).ResolveGlobal(module, field)
+}
+
+// dropVMPools releases the pools of a module evicted from the compiled module cache.
+func dropVMPools(key moduleCacheKey) {
+	vmPoolsLock.Lock()
+	defer vmPoolsLock.Unlock()
+
+	for poolKey := range vmPools {
+		if poolKey.module == key {
+			delete(vmPools, poolKey)
+		}
+	}
+}
+
+// newVM instantiates a new vm from a copy of the shared module, with the limits of the pool.
+func (p *vmPool) newVM() *exec.VirtualMachine {
+	instance := *p.module
+	instance.Config = p.config
+	instance.ImportResolver = nil
+	vm := instance.NewVirtualMachine()
+	if p.config.MaxCallStackDepth > len(vm.CallStack) {
+		vm.CallStack = make([]exec.Frame, p.config.MaxCallStackDepth)
+	}
+	return vm
+}
+
+// get returns an idle vm, or a new one started from the initialized state if none is idle.
+func (p *vmPool) get() (*exec.VirtualMachine, error) {
+	if vm, ok := p.idle.Get().(*exec.VirtualMachine); ok {
+		return vm, nil
+	}
+	vm := p.newVM()
+	if err := p.reset(vm); err != nil {
+		return nil, err
+	}
+	return vm, nil
+}
+
+// put resets a vm which finished its invocation and keeps it for reuse. Vms which cannot
+// be reset are dropped.
+func (p *vmPool) put(vm *exec.VirtualMachine) {
+	if err := p.reset(vm); err != nil {
+		logger.Warningf("Dropping wasm vm which could not be reset: %s", err)
+		return
+	}
+	p.idle.Put(vm)
+}
+
+// reset brings a vm back to the state of a vm which never ran, so no state of an invocation
+// leaks into the next one. The table is not reset as wasm code cannot modify it.
+func (p *vmPool) reset(vm *exec.VirtualMachine) error {
+	memory := vm.Memory
+	if cap(memory) != len(p.memory) {
+		// do not hold on to memory grown by the invocation
+		memory = make([]byte, len(p.memory))
+	}
+	memory = memory[:len(p.memory)]
+	copy(memory, p.memory)
+
+	if err := vm.WriteSnapshot(&exec.Snapshot{State: p.state, Memory: memory}); err != nil {
+		return err
+	}
+	vm.Exited = true
+	vm.ExitError = nil
+	vm.InsideExecute = false
+	vm.Delegate = nil
+	vm.ReturnValue = 0
+	vm.GasLimitExceeded = false
+	vm.StackTrace = ""
+	vm.ImportResolver = nil
+	vm.AOTService = nil
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	opI32Load       = 0x28
+	opI32Store      = 0x36
+	opI32Add        = 0x6a
+	opMemorySize    = 0x3f
+	opI64ExtendI32U = 0xad
+)
+
+// countBody increments the counter at address 0 of memory and returns it.
+var countBody = []byte{
+	opI32Const, 0,
+	opI32Const, 0, opI32Load, 2, 0,
+	opI32Const, 1, opI32Add,
+	opI32Store, 2, 0,
+	opI32Const, 0, opI32Load, 2, 0, opI64ExtendI32U,
+}
+
+// reactorModule sets the counter to 100 in its initializer.
+var reactorModule = buildTestModule(nil, []testFunc{
+	{name: "_initialize", body: []byte{opI32Const, 0, opI32Const, 0xe4, 0, opI32Store, 2, 0}},
+	{name: "init", params: []byte{i64}, results: []byte{i64}, body: returnI64(0)},
+	{name: "count", params: []byte{i64}, results: []byte{i64}, body: countBody},
+}, nil)
+
+var _ = Describe("Tests for pooled wasm vms", func() {
+
+	status200 := int32(200)
+	status500 := int32(500)
+
+	const opMemoryGrow = 0x40
+	pooledModule := buildTestModule(nil, []testFunc{
+		{name: "init", params: []byte{i64}, results: []byte{i64}, body: returnI64(0)},
+		{name: "count", params: []byte{i64}, results: []byte{i64}, body: countBody},
+		{name: "grow", params: []byte{i64}, results: []byte{i64}, body: []byte{opI32Const, 4, opMemoryGrow, 0, opDrop, opMemorySize, 0, opI64ExtendI32U}},
+	}, []byte{41})
+
+	stub := shim.NewMockStub("vmPoolStub", new(WASMChaincode))
+	stub.MockInit("000", nil)
+
+	It("should be created", func() {
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("create"), []byte("pooledwasm"), pooledModule})
+		Expect(result.Status).Should(Equal(status200))
+	})
+	It("should not leak memory writes into the next invocation", func() {
+		for i := 0; i < 3; i++ {
+			result := stub.MockInvoke("000",
+				[][]byte{[]byte("execute"), []byte("pooledwasm"), []byte("count")})
+			Expect(result.Status).Should(Equal(status200))
+			Expect(string(result.Payload)).Should(Equal("42"))
+		}
+	})
+	It("should start every invocation with the initial memory size", func() {
+		for i := 0; i < 2; i++ {
+			result := stub.MockInvoke("000",
+				[][]byte{[]byte("execute"), []byte("pooledwasm"), []byte("grow")})
+			Expect(result.Status).Should(Equal(status200))
+			Expect(string(result.Payload)).Should(Equal("5"))
+		}
+	})
+	It("should reset vms to the state of a new vm", func() {
+		config := defaultRuntimeLimits().vmConfig()
+		key := newModuleCacheKey(pooledModule, config)
+		module, err := loadModule(key, pooledModule, config, &Resolver{})
+		Expect(err).ShouldNot(HaveOccurred())
+		pool, err := vmPoolFor(key, module, config)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		vm, err := pool.get()
+		Expect(err).ShouldNot(HaveOccurred())
+		vm.Memory[0] = 7
+		vm.Memory = append(vm.Memory, make([]byte, 65536)...)
+		vm.Gas = 100
+		vm.CurrentFrame = 3
+		vm.ExitError = "trap"
+
+		Expect(pool.reset(vm)).Should(Succeed())
+		Expect(vm.Memory).Should(Equal(pool.newVM().Memory))
+		Expect(vm.Gas).Should(BeZero())
+		Expect(vm.CurrentFrame).Should(Equal(-1))
+		Expect(vm.ExitError).Should(BeNil())
+	})
+
+	Describe("Reactor modules", func() {
+		It("should start every invocation from the initialized state", func() {
+			result := stub.MockInvoke("000",
+				[][]byte{[]byte("create"), []byte("reactorwasm"), reactorModule})
+			Expect(result.Status).Should(Equal(status200))
+
+			for i := 0; i < 2; i++ {
+				result = stub.MockInvoke("000",
+					[][]byte{[]byte("execute"), []byte("reactorwasm"), []byte("count")})
+				Expect(result.Status).Should(Equal(status200))
+				Expect(string(result.Payload)).Should(Equal("101"))
+			}
+		})
+		It("should start new vms from the initialized state", func() {
+			config := defaultRuntimeLimits().vmConfig()
+			key := newModuleCacheKey(reactorModule, config)
+			module, err := loadModule(key, reactorModule, config, &Resolver{})
+			Expect(err).ShouldNot(HaveOccurred())
+			pool, err := vmPoolFor(key, module, config)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			// idle vms are dropped by the garbage collector
+			pool.idle = sync.Pool{}
+			vm, err := pool.get()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(vm.Memory[0]).Should(Equal(byte(100)))
+		})
+		It("should not run the initializer again on request", func() {
+			result := stub.MockInvoke("000",
+				[][]byte{[]byte("execute"), []byte("reactorwasm"), []byte("_initialize")})
+			Expect(result.Status).Should(Equal(status500))
+			Expect(result.Message).Should(ContainSubstring(`"code":403`))
+		})
+		It("should not create modules whose initializer calls host functions", func() {
+			module := buildTestModule([]testImport{{field: "__print", params: []byte{i32, i32}, results: []byte{i64}}}, []testFunc{
+				{name: "_initialize", body: []byte{opI32Const, 0, opI32Const, 0, opCall, 0, opDrop}},
+				{name: "init", params: []byte{i64}, results: []byte{i64}, body: returnI64(0)},
+			}, nil)
+			result := stub.MockInvoke("000",
+				[][]byte{[]byte("create"), []byte("printingreactorwasm"), module})
+			Expect(result.Status).Should(Equal(status500))
+			Expect(result.Message).Should(ContainSubstring("_initialize failed: host functions cannot be called by _initialize"))
+		})
+		It("should not create modules whose initializer traps", func() {
+			module := buildTestModule(nil, []testFunc{
+				{name: "_initialize", body: []byte{opUnreachable}},
+				{name: "init", params: []byte{i64}, results: []byte{i64}, body: returnI64(0)},
+			}, nil)
+			result := stub.MockInvoke("000",
+				[][]byte{[]byte("create"), []byte("trappingreactorwasm"), module})
+			Expect(result.Status).Should(Equal(status500))
+			Expect(result.Message).Should(ContainSubstring("_initialize failed"))
+		})
+	})
+})
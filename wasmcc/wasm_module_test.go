@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Value types and opcodes used by the hand assembled test modules
+const (
+	i32 = 0x7f
+	i64 = 0x7e
+
+	opUnreachable = 0x00
+	opLoop        = 0x03
+	opEnd         = 0x0b
+	opBr          = 0x0c
+	opCall        = 0x10
+	opDrop        = 0x1a
+	opLocalGet    = 0x20
+	opI32Const    = 0x41
+	opI64Const    = 0x42
+)
+
+// testImport is a host function imported from the env module.
+type testImport struct {
+	field   string
+	params  []byte
+	results []byte
+}
+
+// testFunc is a function defined by a test module. Named functions are exported and
+// listed in the name section.
+type testFunc struct {
+	name    string
+	params  []byte
+	results []byte
+	locals  []byte
+	body    []byte
+}
+
+// buildTestModule assembles a wasm binary with one page of memory, optionally
+// initialized with data at offset 0, and appends any extra custom sections.
+func buildTestModule(imports []testImport, funcs []testFunc, data []byte, customs ...[]byte) []byte {
+	var types, importEntries, functions, exports, codes, names [][]byte
+
+	for i, imp := range imports {
+		types = append(types, funcType(imp.params, imp.results))
+		importEntries = append(importEntries, concat(str("env"), str(imp.field), []byte{0}, uleb(uint32(i))))
+	}
+
+	for i, f := range funcs {
+		index := uint32(len(imports) + i)
+		types = append(types, funcType(f.params, f.results))
+		functions = append(functions, uleb(index))
+
+		var locals [][]byte
+		for _, l := range f.locals {
+			locals = append(locals, []byte{1, l})
+		}
+		body := concat(vec(locals), f.body, []byte{opEnd})
+		codes = append(codes, concat(uleb(uint32(len(body))), body))
+
+		if f.name != "" {
+			exports = append(exports, concat(str(f.name), []byte{0}, uleb(index)))
+			names = append(names, concat(uleb(index), str(f.name)))
+		}
+	}
+	exports = append(exports, concat(str("memory"), []byte{2}, uleb(0)))
+
+	module := concat([]byte("\x00asm"), []byte{1, 0, 0, 0})
+	module = append(module, section(1, vec(types))...)
+	if len(imports) > 0 {
+		module = append(module, section(2, vec(importEntries))...)
+	}
+	module = append(module, section(3, vec(functions))...)
+	module = append(module, section(5, vec([][]byte{{0, 1}}))...)
+	module = append(module, section(7, vec(exports))...)
+	module = append(module, section(10, vec(codes))...)
+	if data != nil {
+		segment := concat([]byte{0, opI32Const, 0, opEnd}, uleb(uint32(len(data))), data)
+		module = append(module, section(11, vec([][]byte{segment}))...)
+	}
+
+	nameSection := concat(str("name"), []byte{1}, uleb(uint32(len(vec(names)))), vec(names))
+	module = append(module, section(0, nameSection)...)
+	for _, custom := range customs {
+		module = append(module, section(0, custom)...)
+	}
+	return module
+}
+
+// customSection builds the payload of a custom section for buildTestModule.
+func customSection(name string, payload []byte) []byte {
+	return concat(str(name), payload)
+}
+
+func funcType(params, results []byte) []byte {
+	return concat([]byte{0x60}, uleb(uint32(len(params))), params, uleb(uint32(len(results))), results)
+}
+
+func section(id byte, payload []byte) []byte {
+	return concat([]byte{id}, uleb(uint32(len(payload))), payload)
+}
+
+func vec(items [][]byte) []byte {
+	return concat(uleb(uint32(len(items))), concat(items...))
+}
+
+func str(s string) []byte {
+	return concat(uleb(uint32(len(s))), []byte(s))
+}
+
+func uleb(v uint32) []byte {
+	buf := make([]byte, binary.MaxVarintLen32)
+	return buf[:binary.PutUvarint(buf, uint64(v))]
+}
+
+func sleb(v int64) []byte {
+	var out []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0) {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// returnI64 is a function body returning a constant.
+func returnI64(v int64) []byte {
+	return concat([]byte{opI64Const}, sleb(v))
+}
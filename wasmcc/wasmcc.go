@@ -8,8 +8,8 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/h2non/filetype"
@@ -17,16 +17,21 @@ import (
 	"github.com/hyperledger/fabric/common/flogging"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
-
-	"github.com/perlin-network/life/exec"
-	wasm_validation "github.com/perlin-network/life/wasm-validation"
 )
 
 //Exception messages for WASMCC
 const (
-	ChaincodeExists = "{\"code\":401, \"reason\": \"chaincode exist with same name\"}"
-	UnknownError    = "{\"code\":402, \"reason\": \"unknown error : %s\"}"
-	FnNotPresent    = "{\"code\":403, \"reason\": \"function doesn't exist in installed wasm chaincode : %s\"}"
+	ChaincodeExists  = "{\"code\":401, \"reason\": \"chaincode exist with same name\"}"
+	UnknownError     = "{\"code\":402, \"reason\": \"unknown error : %s\"}"
+	FnNotPresent     = "{\"code\":403, \"reason\": \"function doesn't exist in installed wasm chaincode : %s\"}"
+	ExecutionFailed  = "{\"code\":404, \"reason\": \"wasm chaincode execution failed : %s\"}"
+	InvalidConfig    = "{\"code\":405, \"reason\": \"invalid configuration : %s\"}"
+	ArgsTooLarge     = "{\"code\":406, \"reason\": \"transaction parameters of %d bytes exceed limit of %d bytes\"}"
+	CodeHashMismatch = "{\"code\":407, \"reason\": \"code of chaincode %s has hash %s, expected %s\"}"
+	ChaincodePaused  = "{\"code\":408, \"reason\": \"chaincode %s is paused\"}"
+	AccessDenied     = "{\"code\":409, \"reason\": \"access denied : %s\"}"
+	NotApproved      = "{\"code\":410, \"reason\": \"chaincode %s has %d of %d required approvals\"}"
+	ModuleNotAllowed = "{\"code\":411, \"reason\": \"wasm module not allowed : %s\"}"
 )
 
 //Exception messages for Host Functions
@@ -38,24 +43,45 @@ const (
 
 var logger = flogging.MustGetLogger("wasmcc")
 
+//In dev mode wasm stack traces are returned to the client along with the error
+var devMode = os.Getenv("WASMCC_DEVMODE") == "true"
+
 type WASMChaincode struct {
 }
 
-// Resolver defines imports for WebAssembly modules ran in Life.
+// Resolver defines imports for WebAssembly modules ran in a wasm engine.
 type Resolver struct {
 	//Global variables to be used by exported wasm functions
 	chaincodeName string
 	stub          shim.ChaincodeStubInterface
-	args          []string
+	state         *stateOverlay
+	args          [][]byte
 	result        []byte
+	response      *pb.Response
 	errMsg        []byte
+	errCode       int64
+	exceptionMsg  []byte
+	symbols       *moduleSymbols
+	gasUsed       uint64
+	gasSchedule   *hostGasSchedule
+	hostGasUsed   uint64
+}
+
+// wasmTrap is returned when a wasm chaincode aborts during execution.
+type wasmTrap struct {
+	err   error
+	trace string
+}
+
+func (t *wasmTrap) Error() string {
+	return t.err.Error()
 }
 
 //Index Names
 var chaincodeStoreIndex = "chaincodeData"
 
-// ResolveFunc defines a set of import functions that may be called within a WebAssembly module.
-func (r *Resolver) ResolveFunc(module, field string) exec.FunctionImport {
+// hostFunction defines a set of import functions that may be called within a WebAssembly module.
+func (r *Resolver) hostFunction(module, field string) hostFunc {
 
 	logger.Info("Resolve func: %s %s\n", module, field)
 
@@ -63,31 +89,34 @@ func (r *Resolver) ResolveFunc(module, field string) exec.FunctionImport {
 	case "env":
 		switch field {
 		case "__print":
-			return func(vm *exec.VirtualMachine) int64 {
-				ptr := int(uint32(vm.GetCurrentFrame().Locals[0]))
-				msgLen := int(uint32(vm.GetCurrentFrame().Locals[1]))
-				msg := vm.Memory[ptr : ptr+msgLen]
+			return func(env hostEnv, params []int64) int64 {
+				ptr := int(uint32(params[0]))
+				msgLen := int(uint32(params[1]))
+				r.chargeHostCall(env, field, 0, 0, 0)
+				msg := env.memory()[ptr : ptr+msgLen]
 
 				logger.Debugf("[__print] data at pointer location : %s\n", string(msg))
 
 				return 0
 			}
 		case "__get_parameter":
-			return func(vm *exec.VirtualMachine) int64 {
-				paramNumber := int(uint32(vm.GetCurrentFrame().Locals[0]))
-				ptrForResult := int(uint32(vm.GetCurrentFrame().Locals[1]))
+			return func(env hostEnv, params []int64) int64 {
+				paramNumber := int(uint32(params[0]))
+				ptrForResult := int(uint32(params[1]))
+				r.chargeHostCall(env, field, 0, 0, 0)
 
 				//Check if argument contains this many elements
-				if len(r.args) < paramNumber {
-					r.errMsg = []byte(TxnParameterOutOfBound)
+				if paramNumber >= len(r.args) {
+					r.setLastError(errCodeOutOfBounds, TxnParameterOutOfBound)
 					logger.Errorf(TxnParameterOutOfBound)
 					return -1
 				}
 
 				paramToReturn := r.args[paramNumber]
+				r.chargeHostBytes(env, len(paramToReturn), 0)
 
 				//Memory location for storing parameter
-				result := vm.Memory[ptrForResult : ptrForResult+len(paramToReturn)]
+				result := env.memory()[ptrForResult : ptrForResult+len(paramToReturn)]
 
 				//Copying the getState parameter to above memory location
 				copy(result, paramToReturn)
@@ -98,12 +127,13 @@ func (r *Resolver) ResolveFunc(module, field string) exec.FunctionImport {
 				return int64(len(paramToReturn))
 			}
 		case "__get_parameter_size":
-			return func(vm *exec.VirtualMachine) int64 {
-				paramNumber := int(uint32(vm.GetCurrentFrame().Locals[0]))
+			return func(env hostEnv, params []int64) int64 {
+				paramNumber := int(uint32(params[0]))
+				r.chargeHostCall(env, field, 0, 0, 0)
 
 				//Check if argument contains this many elements
-				if len(r.args) < paramNumber {
-					r.errMsg = []byte(TxnParameterOutOfBound)
+				if paramNumber >= len(r.args) {
+					r.setLastError(errCodeOutOfBounds, TxnParameterOutOfBound)
 					logger.Errorf(TxnParameterOutOfBound)
 					return -1
 				}
@@ -116,35 +146,38 @@ func (r *Resolver) ResolveFunc(module, field string) exec.FunctionImport {
 				return int64(paramToReturn)
 			}
 		case "__get_state":
-			return func(vm *exec.VirtualMachine) int64 {
+			return func(env hostEnv, params []int64) int64 {
 
 				//Pointer and length for key
-				ptr := int(uint32(vm.GetCurrentFrame().Locals[0]))
-				msgLen := int(uint32(vm.GetCurrentFrame().Locals[1]))
+				ptr := int(uint32(params[0]))
+				msgLen := int(uint32(params[1]))
 
 				//Pointer for value to be returned
-				ptr2 := int(uint32(vm.GetCurrentFrame().Locals[2]))
+				ptr2 := int(uint32(params[2]))
 
-				msg := vm.Memory[ptr : ptr+msgLen]
+				r.chargeHostCall(env, field, msgLen, 0, 0)
+				msg := env.memory()[ptr : ptr+msgLen]
 				logger.Debugf("[__get_state] key at passed pointer: %s\n", string(msg))
 
 				s := fmt.Sprintf("%s_%s", r.chaincodeName, msg)
 
-				valueFromState, err := r.stub.GetState(s)
+				valueFromState, err := r.state.get(s)
 
 				if err != nil {
-					r.errMsg = []byte(err.Error())
+					r.setLastError(errCodeLedgerError, err.Error())
 					logger.Errorf(ErrorOccurred, err.Error())
 					return -1
 				}
 				if valueFromState == nil {
-					r.errMsg = []byte(NoResultForGetState)
+					r.setLastError(errCodeNotFound, NoResultForGetState)
 					logger.Errorf(NoResultForGetState)
 					return -1
 				}
 
+				r.chargeHostBytes(env, len(valueFromState), 0)
+
 				//Memory location for storing result of getState
-				result := vm.Memory[ptr2 : ptr2+len(valueFromState)]
+				result := env.memory()[ptr2 : ptr2+len(valueFromState)]
 
 				//Copying the getState result to above memory location
 				copy(result, valueFromState)
@@ -155,26 +188,27 @@ func (r *Resolver) ResolveFunc(module, field string) exec.FunctionImport {
 				return int64(len(valueFromState))
 			}
 		case "__get_state_size":
-			return func(vm *exec.VirtualMachine) int64 {
+			return func(env hostEnv, params []int64) int64 {
 
 				//Pointer and length for key
-				ptr := int(uint32(vm.GetCurrentFrame().Locals[0]))
-				msgLen := int(uint32(vm.GetCurrentFrame().Locals[1]))
+				ptr := int(uint32(params[0]))
+				msgLen := int(uint32(params[1]))
 
-				msg := vm.Memory[ptr : ptr+msgLen]
+				r.chargeHostCall(env, field, msgLen, 0, 0)
+				msg := env.memory()[ptr : ptr+msgLen]
 				logger.Debugf("[__get_state] key at passed pointer: %s\n", string(msg))
 
 				s := fmt.Sprintf("%s_%s", r.chaincodeName, msg)
 
-				valueFromState, err := r.stub.GetState(s)
+				valueFromState, err := r.state.get(s)
 
 				if err != nil {
-					r.errMsg = []byte(err.Error())
+					r.setLastError(errCodeLedgerError, err.Error())
 					logger.Errorf(ErrorOccurred, err.Error())
 					return -1
 				}
 				if valueFromState == nil {
-					r.errMsg = []byte(NoResultForGetState)
+					r.setLastError(errCodeNotFound, NoResultForGetState)
 					logger.Errorf(NoResultForGetState)
 					return -1
 				}
@@ -185,62 +219,112 @@ func (r *Resolver) ResolveFunc(module, field string) exec.FunctionImport {
 				return int64(len(valueFromState))
 			}
 		case "__put_state":
-			return func(vm *exec.VirtualMachine) int64 {
+			return func(env hostEnv, params []int64) int64 {
 
 				//Pointer and length for key
-				keyPtr := int(uint32(vm.GetCurrentFrame().Locals[0]))
-				keyMsgLen := int(uint32(vm.GetCurrentFrame().Locals[1]))
-				key := vm.Memory[keyPtr : keyPtr+keyMsgLen]
+				keyPtr := int(uint32(params[0]))
+				keyMsgLen := int(uint32(params[1]))
+				key := env.memory()[keyPtr : keyPtr+keyMsgLen]
 
 				//Pointer and length for value
-				valuePtr := int(uint32(vm.GetCurrentFrame().Locals[2]))
-				valueMsgLen := int(uint32(vm.GetCurrentFrame().Locals[3]))
-				value := vm.Memory[valuePtr : valuePtr+valueMsgLen]
+				valuePtr := int(uint32(params[2]))
+				valueMsgLen := int(uint32(params[3]))
+				//Copy the value, the stub may keep it after the wasm memory is released
+				value := make([]byte, valueMsgLen)
+				copy(value, env.memory()[valuePtr:valuePtr+valueMsgLen])
 
+				r.chargeHostCall(env, field, keyMsgLen, 0, valueMsgLen)
 				logger.Debugf("[__put_state] key: %s and value: %s\n", string(key), string(value))
 
 				s := fmt.Sprintf("%s_%s", r.chaincodeName, key)
 
-				// Store the key, value in ledger
-				err := r.stub.PutState(s, value)
-				if err != nil {
-					r.errMsg = []byte(err.Error())
-					logger.Errorf(ErrorOccurred, err.Error())
-					return -1
-				}
+				// Store the key, value in ledger when the invocation succeeds
+				r.state.put(s, value)
 				return 0
 			}
 		case "__delete_state":
-			return func(vm *exec.VirtualMachine) int64 {
+			return func(env hostEnv, params []int64) int64 {
 
 				//Pointer and length for key
-				ptr := int(uint32(vm.GetCurrentFrame().Locals[0]))
-				msgLen := int(uint32(vm.GetCurrentFrame().Locals[1]))
+				ptr := int(uint32(params[0]))
+				msgLen := int(uint32(params[1]))
 
-				msg := vm.Memory[ptr : ptr+msgLen]
+				r.chargeHostCall(env, field, msgLen, 0, 0)
+				msg := env.memory()[ptr : ptr+msgLen]
 
 				logger.Debugf("[__delete_state] key at passed pointer: %s\n", string(msg))
 
 				s := fmt.Sprintf("%s_%s", r.chaincodeName, msg)
 
-				err := r.stub.DelState(s)
+				r.state.del(s)
+
+				//Returning length of value
+				return 0
+			}
+		case "__get_states_size":
+			return func(env hostEnv, params []int64) int64 {
+
+				//Pointer and length for packed keys
+				ptr := int(uint32(params[0]))
+				msgLen := int(uint32(params[1]))
+
+				values, err := r.getStates(env, field, env.memory()[ptr:ptr+msgLen])
 				if err != nil {
-					r.errMsg = []byte(err.Error())
+					r.setLastError(batchErrorCode(err), err.Error())
 					logger.Errorf(ErrorOccurred, err.Error())
 					return -1
 				}
 
-				//Returning length of value
+				//Returning length of packed values
+				return int64(len(values))
+			}
+		case "__get_states":
+			return func(env hostEnv, params []int64) int64 {
+
+				//Pointer and length for packed keys
+				ptr := int(uint32(params[0]))
+				msgLen := int(uint32(params[1]))
+
+				//Pointer for packed values to be returned
+				ptr2 := int(uint32(params[2]))
+
+				values, err := r.getStates(env, field, env.memory()[ptr:ptr+msgLen])
+				if err != nil {
+					r.setLastError(batchErrorCode(err), err.Error())
+					logger.Errorf(ErrorOccurred, err.Error())
+					return -1
+				}
+				r.chargeHostBytes(env, len(values), 0)
+
+				copy(env.memory()[ptr2:ptr2+len(values)], values)
+
+				//Returning length of packed values
+				return int64(len(values))
+			}
+		case "__put_states":
+			return func(env hostEnv, params []int64) int64 {
+
+				//Pointer and length for packed keys and values
+				ptr := int(uint32(params[0]))
+				msgLen := int(uint32(params[1]))
+
+				err := r.putStates(env, field, env.memory()[ptr:ptr+msgLen])
+				if err != nil {
+					r.setLastError(batchErrorCode(err), err.Error())
+					logger.Errorf(ErrorOccurred, err.Error())
+					return -1
+				}
 				return 0
 			}
 		case "__return_result":
-			return func(vm *exec.VirtualMachine) int64 {
+			return func(env hostEnv, params []int64) int64 {
 
 				//Pointer and length for key
-				ptr := int(uint32(vm.GetCurrentFrame().Locals[0]))
-				msgLen := int(uint32(vm.GetCurrentFrame().Locals[1]))
+				ptr := int(uint32(params[0]))
+				msgLen := int(uint32(params[1]))
 
-				msg := vm.Memory[ptr : ptr+msgLen]
+				r.chargeHostCall(env, field, 0, 0, msgLen)
+				msg := env.memory()[ptr : ptr+msgLen]
 
 				logger.Debugf("[__return_result] message received at passed pointer: %s\n", string(msg))
 
@@ -249,19 +333,73 @@ func (r *Resolver) ResolveFunc(module, field string) exec.FunctionImport {
 				//Returning length of value
 				return 0
 			}
+		case "__set_response":
+			return func(env hostEnv, params []int64) int64 {
+
+				//Status code, pointers and lengths for message and payload
+				status := int32(params[0])
+				msgPtr := int(uint32(params[1]))
+				msgLen := int(uint32(params[2]))
+				payloadPtr := int(uint32(params[3]))
+				payloadLen := int(uint32(params[4]))
+
+				r.chargeHostCall(env, field, 0, 0, msgLen+payloadLen)
+				msg := env.memory()[msgPtr : msgPtr+msgLen]
+				payload := env.memory()[payloadPtr : payloadPtr+payloadLen]
+
+				logger.Debugf("[__set_response] status: %d, message: %s\n", status, string(msg))
+
+				if err := r.setResponse(status, msg, payload); err != nil {
+					r.setLastError(errCodeInvalidArgument, err.Error())
+					logger.Errorf(ErrorOccurred, err.Error())
+					return -1
+				}
+				return 0
+			}
+		case "__last_error_code":
+			return func(env hostEnv, params []int64) int64 {
+				r.chargeHostCall(env, field, 0, 0, 0)
+
+				//Returning code of the last error
+				return r.errCode
+			}
+		case "__last_error_size":
+			return func(env hostEnv, params []int64) int64 {
+				r.chargeHostCall(env, field, 0, 0, 0)
+
+				//Returning length of the last error message
+				return int64(len(r.errMsg))
+			}
+		case "__last_error":
+			return func(env hostEnv, params []int64) int64 {
+
+				//Pointer for error message to be returned
+				ptr := int(uint32(params[0]))
+
+				r.chargeHostCall(env, field, 0, len(r.errMsg), 0)
+
+				//Copying the last error message to the memory location
+				copy(env.memory()[ptr:ptr+len(r.errMsg)], r.errMsg)
+
+				logger.Debugf("[__last_error] error message being returned in pointer: %s\n", string(r.errMsg))
+
+				//Returning length of error message
+				return int64(len(r.errMsg))
+			}
 		case "__get_exception_msg":
-			return func(vm *exec.VirtualMachine) int64 {
+			return func(env hostEnv, params []int64) int64 {
 
 				//Pointer and length for key
-				ptr := int(uint32(vm.GetCurrentFrame().Locals[0]))
-				msgLen := int(uint32(vm.GetCurrentFrame().Locals[1]))
+				ptr := int(uint32(params[0]))
+				msgLen := int(uint32(params[1]))
 
-				msg := vm.Memory[ptr : ptr+msgLen]
+				r.chargeHostCall(env, field, 0, 0, msgLen)
+				msg := env.memory()[ptr : ptr+msgLen]
 
 				logger.Debugf("[__get_exception_msg] error message being returned in pointer: %s\n", string(msg))
 
-				r.errMsg = make([]byte, msgLen)
-				copy(r.errMsg, msg)
+				r.exceptionMsg = make([]byte, msgLen)
+				copy(r.exceptionMsg, msg)
 				//Returning length of value
 				return 0
 			}
@@ -291,107 +429,280 @@ func (r *Resolver) ResolveGlobal(module, field string) int64 {
 
 func (t *WASMChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 	logger.Infof("Init invoked")
-	return shim.Success(nil)
+	//wasmcc may be instantiated or upgraded with the configuration of the channel
+	_, args := functionAndRawArgs(stub)
+	if len(args) == 0 {
+		return shim.Success(nil)
+	}
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting configuration as json")
+	}
+	return mergeConfig(stub, args[0])
 }
 
 func (t *WASMChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	logger.Infof("Invoke function")
-	function, args := stub.GetFunctionAndParameters()
-	logger.Debugf("Invoke function %s with args %v", function, args)
+	//Arguments are kept as bytes, so binary parameters reach wasm chaincodes unmodified
+	function, rawArgs := functionAndRawArgs(stub)
+	args := stringArgs(rawArgs)
+	logger.Debugf("Invoke function %s with %d args", function, len(rawArgs))
+
+	//With an approval policy the code run by chaincodes is only changed by commit
+	if function == "create" || function == "upgrade" || function == "rollback" || function == "setTag" {
+		if errResponse := checkApprovalNotRequired(stub); errResponse != nil {
+			return *errResponse
+		}
+	}
 
 	if function == "create" {
 		// Create a new wasm chaincode
-		return t.create(stub, args)
+		return t.create(stub, rawArgs)
 	} else if function == "execute" {
 		// execute wasm chaincode
-		return t.execute(stub, args)
+		return t.execute(stub, rawArgs)
+	} else if function == "upgrade" {
+		// replace the code of a wasm chaincode with a new version
+		return t.upgrade(stub, rawArgs)
+	} else if function == "rollback" {
+		// make a previous version of a wasm chaincode the active one
+		return t.rollback(stub, args)
+	} else if function == "setTag" {
+		// point a tag of a wasm chaincode to one of its versions
+		return t.setTag(stub, args)
+	} else if function == "removeTag" {
+		// delete a tag of a wasm chaincode
+		return t.removeTag(stub, args)
+	} else if function == "collectModules" {
+		// delete stored wasm modules which are not used any more
+		return t.collectModules(stub)
+	} else if function == "pause" {
+		// reject execute of a wasm chaincode until it is resumed
+		return t.pause(stub, args)
+	} else if function == "resume" {
+		// accept execute of a paused wasm chaincode again
+		return t.resume(stub, args)
+	} else if function == "delete" {
+		// remove a wasm chaincode, optionally purging its state
+		return t.delete(stub, args)
+	} else if function == "purgeState" {
+		// delete the next batch of state left by a deleted wasm chaincode
+		return t.purgeState(stub, args)
+	} else if function == "owner" {
+		// give back the owner of a wasm chaincode
+		return t.owner(stub, args)
+	} else if function == "transferOwnership" {
+		// make another identity the owner of a wasm chaincode
+		return t.transferOwnership(stub, args)
+	} else if function == "setAdmins" {
+		// replace the admins of the channel
+		return t.setAdmins(stub, args)
+	} else if function == "admins" {
+		// give back the admins of the channel
+		return t.admins(stub)
+	} else if function == "setApprovalPolicy" {
+		// set the organizations approving wasm chaincode deployments
+		return t.setApprovalPolicy(stub, args)
+	} else if function == "approvalPolicy" {
+		// give back the approval policy of the channel
+		return t.approvalPolicy(stub)
+	} else if function == "propose" {
+		// propose code and init parameters of a wasm chaincode
+		return t.propose(stub, rawArgs)
+	} else if function == "approve" {
+		// approve the proposal of a wasm chaincode for the organization of the creator
+		return t.approve(stub, args)
+	} else if function == "proposal" {
+		// give back the proposal of a wasm chaincode
+		return t.proposal(stub, args)
+	} else if function == "withdraw" {
+		// delete the proposal of a wasm chaincode
+		return t.withdraw(stub, args)
+	} else if function == "commit" {
+		// create or upgrade a wasm chaincode as approved
+		return t.commit(stub, rawArgs)
+	} else if function == "updateConfig" {
+		// change the configuration of the channel
+		return t.updateConfig(stub, args)
+	} else if function == "config" {
+		// give back the configuration of the channel
+		return t.config(stub)
+	} else if function == "configHistory" {
+		// give back the changes of the configuration of the channel
+		return t.configHistory(stub)
 	} else if function == "installedChaincodes" {
 		// invoke a new wasm chaincode
 		return t.installedChaincodes(stub, args)
+	} else if function == "describe" {
+		// describe the wasm module of a chaincode
+		return t.describe(stub, args)
+	} else if function == "downloadModule" {
+		// give back the stored module of a version of a wasm chaincode
+		return t.downloadModule(stub, args)
+	} else if function == "setHostGasSchedule" {
+		// update the prices of host function calls
+		return t.setHostGasSchedule(stub, args)
+	} else if function == "hostGasSchedule" {
+		// query the prices of host function calls
+		return t.hostGasSchedule(stub)
+	} else if function == "setRuntimeLimits" {
+		// update the runtime limits of the channel or of a chaincode
+		return t.setRuntimeLimits(stub, args)
+	} else if function == "runtimeLimits" {
+		// query the runtime limits in effect
+		return t.runtimeLimits(stub, args)
+	} else if function == "setEngine" {
+		// select the wasm engine of the channel
+		return t.setEngine(stub, args)
+	} else if function == "engine" {
+		// query the wasm engine of the channel
+		return t.engine(stub)
 	}
 
-	return shim.Error("Invalid invoke function name. Expecting \"execute\" \"create\" \"query\"")
+	return shim.Error("Invalid invoke function name. Expecting \"execute\" \"create\" \"upgrade\" \"rollback\" \"setTag\" \"removeTag\" \"collectModules\" \"pause\" \"resume\" \"delete\" \"purgeState\" \"owner\" \"transferOwnership\" \"setAdmins\" \"admins\" \"setApprovalPolicy\" \"approvalPolicy\" \"propose\" \"approve\" \"proposal\" \"withdraw\" \"commit\" \"updateConfig\" \"config\" \"configHistory\" \"installedChaincodes\" \"describe\" \"downloadModule\" \"setHostGasSchedule\" \"hostGasSchedule\" \"setRuntimeLimits\" \"runtimeLimits\" \"setEngine\" \"engine\"")
 }
 
-func (t *WASMChaincode) installedChaincodes(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-
-	// Get all chaincodes from the ledger
-	installedChaincodeResultsIterator, err := stub.GetStateByPartialCompositeKey(chaincodeStoreIndex, []string{})
-	if err != nil {
-		return shim.Error(err.Error())
+// functionAndRawArgs splits the arguments of the transaction into the function name and its
+// parameters, like GetFunctionAndParameters but without converting parameters to strings.
+func functionAndRawArgs(stub shim.ChaincodeStubInterface) (string, [][]byte) {
+	args := stub.GetArgs()
+	if len(args) == 0 {
+		return "", nil
 	}
+	return string(args[0]), args[1:]
+}
 
-	// Iterate through result set and get chaincode names
-	var i int
-	var installedChaincodeNamesList = ""
-
-	for i = 0; installedChaincodeResultsIterator.HasNext(); i++ {
-		// Note that we don't get the value (2nd return variable), we'll just get the marble name from the composite key
-		responseRange, err := installedChaincodeResultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-
-		// get the color and name from color~name composite key
-		objectType, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		returnedChaincodeName := compositeKeyParts[0]
-		logger.Infof("- found a chaincode from index:%s name:%s\n", objectType, returnedChaincodeName)
-		installedChaincodeNamesList += returnedChaincodeName
-		installedChaincodeNamesList += "\n"
-
+// stringArgs converts parameters for functions which only accept text.
+func stringArgs(rawArgs [][]byte) []string {
+	args := make([]string, len(rawArgs))
+	for i, arg := range rawArgs {
+		args[i] = string(arg)
 	}
-
-	logger.Infof("Invoke Response:%s\n", installedChaincodeNamesList)
-	return shim.Success([]byte(installedChaincodeNamesList))
+	return args
 }
 
-func (t *WASMChaincode) execute(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+func (t *WASMChaincode) execute(stub shim.ChaincodeStubInterface, args [][]byte) pb.Response {
 
 	if len(args) < 2 {
 		return shim.Error("Incorrect number of arguments. Expecting chaincode name to invoke")
 	}
 
-	chaincodeName := args[0]
-	funcToInvoke := args[1]
+	//Chaincodes may be pinned to a version kept in their history and to a code hash
+	ref, err := parseChaincodeRef(string(args[0]))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	chaincodeName := ref.name
+	funcToInvoke := string(args[1])
+
+	gasSchedule, err := loadHostGasSchedule(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+
+	limits, err := loadRuntimeLimits(stub, chaincodeName)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+
+	engine, err := loadEngine(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(InvalidConfig, err.Error()))
+	}
+	if err := limits.checkArgsSize(args[2:]); err != nil {
+		return shim.Error(err.Error())
+	}
 
 	//Initialize global variables for exported wasm functions
 	r := Resolver{
-		chaincodeName, stub, args[2:], nil, nil,
+		chaincodeName: chaincodeName,
+		stub:          stub,
+		state:         newStateOverlay(stub),
+		args:          args[2:],
+		gasSchedule:   gasSchedule,
 	}
 
 	// Get the state from the ledger
-	ledgerChaincodeKey, _ := stub.CreateCompositeKey(chaincodeStoreIndex, []string{chaincodeName})
-	Chaincodebytes, _ := stub.GetState(ledgerChaincodeKey)
-	if Chaincodebytes == nil {
-		jsonResp := "{\"Error\":\"No Chaincode for " + chaincodeName + "\"}"
-		return shim.Error(jsonResp)
+	Chaincodebytes, errResponse := loadChaincodeCode(stub, ref)
+	if errResponse != nil {
+		return *errResponse
+	}
+	if err := ref.checkCodeHash(Chaincodebytes); err != nil {
+		return shim.Error(err.Error())
+	}
+	metadata, err := loadMetadata(stub, chaincodeName, Chaincodebytes)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	if metadata.Paused {
+		return shim.Error(fmt.Sprintf(ChaincodePaused, chaincodeName))
+	}
+	if ref.version != 0 || ref.tag != "" {
+		//Stored symbols belong to the active version
+		r.symbols, _ = parseModuleSymbols(Chaincodebytes)
 	}
 
-	result := runWASM(Chaincodebytes, funcToInvoke, len(args)-2, &r)
+	result, err := runWASM(engine, Chaincodebytes, funcToInvoke, len(args)-2, limits, &r)
+	if err != nil {
+		return withGasUsed(stub, executionError(err), r.gasUsed)
+	}
+
+	logger.Infof("Invoke Response:%d, gas used:%d\n", result, r.gasUsed)
 
-	logger.Infof("Invoke Response:%d\n", result)
-	return txnResult(result, r.result)
+	//State changes are only applied by successful invocations
+	if invocationSucceeded(result, &r) {
+		if err := r.state.flush(); err != nil {
+			return withGasUsed(stub, shim.Error(fmt.Sprintf(UnknownError, err.Error())), r.gasUsed)
+		}
+	}
+	return withGasUsed(stub, invocationResponse(result, &r), r.gasUsed)
 }
 
 // Store a new wasm chaincode in state. Receives chaincode name and wasm file encoded in hex
-func (t *WASMChaincode) create(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+func (t *WASMChaincode) create(stub shim.ChaincodeStubInterface, args [][]byte) pb.Response {
 	logger.Infof("Create function")
+
+	//The creator owns the chaincode
+	creator, err := creatorIdentity(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	return t.createOwnedBy(stub, args, creator)
+}
+
+// createOwnedBy stores a new wasm chaincode owned by an identity, the creator of the
+// transaction or the proposer of a committed proposal.
+func (t *WASMChaincode) createOwnedBy(stub shim.ChaincodeStubInterface, args [][]byte, creator *identity) pb.Response {
 	if len(args) < 2 {
 		return shim.Error("Incorrect number of arguments. Expecting atleast 2 arguments")
 	}
 
-	chaincodeName := args[0]
+	chaincodeName := string(args[0])
 	logger.Infof("Installing wasm chaincode: " + chaincodeName)
+	if err := checkChaincodeName(chaincodeName); err != nil {
+		return shim.Error(err.Error())
+	}
 
 	//check if same chaincode name is already present
-	chaincodeFromState, err := stub.GetState(chaincodeName)
+	ledgerChaincodeKey, err := stub.CreateCompositeKey(chaincodeStoreIndex, []string{chaincodeName})
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	chaincodeFromState, err := stub.GetState(ledgerChaincodeKey)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
 	if chaincodeFromState != nil {
 		return shim.Error(ChaincodeExists)
 	}
 
+	config, err := loadChannelConfig(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	if err := config.checkCreator(creator); err != nil {
+		return shim.Error(err.Error())
+	}
+
 	//Decode the chaincode
 	chaincodeHexEncoded := args[1]
 	chaincodeDecoded, err := decodeReceivedWASMChaincode(chaincodeHexEncoded)
@@ -399,74 +710,157 @@ func (t *WASMChaincode) create(stub shim.ChaincodeStubInterface, args []string)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	if err := config.checkModule(chaincodeDecoded); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	symbols, err := parseModuleSymbols(chaincodeDecoded)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	gasSchedule, err := loadHostGasSchedule(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+
+	limits, err := loadRuntimeLimits(stub, chaincodeName)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+
+	engine, err := loadEngine(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(InvalidConfig, err.Error()))
+	}
+	if err := engine.validate(chaincodeDecoded); err != nil {
+		return executionError(err)
+	}
+	if err := limits.checkArgsSize(args[2:]); err != nil {
+		return shim.Error(err.Error())
+	}
 
 	//Initialize global variables for exported wasm functions
 	r := Resolver{
-		chaincodeName, stub, args[2:], nil, nil,
+		chaincodeName: chaincodeName,
+		stub:          stub,
+		state:         newStateOverlay(stub),
+		args:          args[2:],
+		symbols:       symbols,
+		gasSchedule:   gasSchedule,
 	}
 
-	result := runWASM(chaincodeDecoded, "init", len(args)-2, &r)
+	result, err := runWASM(engine, chaincodeDecoded, "init", len(args)-2, limits, &r)
+	if err != nil {
+		return withGasUsed(stub, executionError(err), r.gasUsed)
+	}
 
-	logger.Infof("Init Response:%d\n", result)
+	logger.Infof("Init Response:%d, gas used:%d\n", result, r.gasUsed)
 
 	if result != 0 {
-		return shim.Error("Chaincode init invocation failed")
+		return withGasUsed(stub, shim.Error("Chaincode init invocation failed"), r.gasUsed)
+	}
+	if !invocationSucceeded(result, &r) {
+		return withGasUsed(stub, invocationResponse(result, &r), r.gasUsed)
+	}
+	if err := r.state.flush(); err != nil {
+		return withGasUsed(stub, shim.Error(fmt.Sprintf(UnknownError, err.Error())), r.gasUsed)
 	}
 
 	// Store the chaincode in
-	ledgerChaincodeKey, err := stub.CreateCompositeKey(chaincodeStoreIndex, []string{chaincodeName})
-	err = stub.PutState(ledgerChaincodeKey, chaincodeDecoded)
+	_, createdAt, err := transactionTime(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf(UnknownError, err.Error()))
+	}
+	metadata := &chaincodeMetadata{
+		Version:       1,
+		LatestVersion: 1,
+		CodeHash:      codeHash(chaincodeDecoded),
+		CreatorMSP:    creator.mspID,
+		CreateTxID:    stub.GetTxID(),
+		CreatedAt:     createdAt,
+	}
+	err = storeChaincode(newModuleStore(stub), chaincodeName, chaincodeDecoded, symbols, metadata)
+	if err == nil {
+		err = storeOwner(stub, chaincodeName, &chaincodeOwner{MSPID: creator.mspID, Certificate: string(creator.certificate)})
+	}
 	if err != nil {
 		s := fmt.Sprintf(UnknownError, err.Error())
 		return shim.Error(s)
 	}
-	return shim.Success([]byte("Success! Installed wasm chaincode"))
+	return withGasUsed(stub, shim.Success([]byte("Success! Installed wasm chaincode")), r.gasUsed)
 }
 
 // query callback representing the query of a chaincode
-func runWASM(Chaincodebytes []byte, funcToInvoke string, numberOfArgs int, r *Resolver) int64 {
+func runWASM(engine wasmEngine, Chaincodebytes []byte, funcToInvoke string, numberOfArgs int, limits runtimeLimits, r *Resolver) (_ int64, retErr error) {
 
 	//entryFunctionFlag := flag.String("entry", funcToInvoke, "entry function name")
 	//noFloatingPointFlag := flag.Bool("no-fp", false, "disable floating point")
 	flag.Parse()
 
-	err := wasm_validation.ValidateWasm(Chaincodebytes)
-	if err != nil {
-		panic(err)
-	}
+	//engines may panic on invalid invocations, e.g. a parameter count mismatch
+	defer func() {
+		if err := recover(); err != nil {
+			retErr = fmt.Errorf("%v", err)
+		}
+	}()
 
-	// Instantiate a new WebAssembly VM with a few resolved imports.
-	vm, err := exec.NewVirtualMachine(Chaincodebytes, exec.VMConfig{
-		DefaultMemoryPages:   128,
-		DefaultTableSize:     65536,
-		DisableFloatingPoint: false,
-	}, r, nil)
+	//The initializer of reactor modules only runs before the first invocation
+	if funcToInvoke == moduleInitializer {
+		r.result = []byte(FnNotPresent)
+		return -1, nil
+	}
 
+	module, err := engine.load(Chaincodebytes, limits)
 	if err != nil {
-		panic(err)
+		return -1, err
 	}
 
-	// Get the function ID of the entry function to be executed.
-	entryID, ok := vm.GetFunctionExport(funcToInvoke)
-	if !ok {
-		logger.Errorf("Entry function %s not found; starting from 0.\n", funcToInvoke)
-		r.result = []byte(FnNotPresent)
-		return -1
+	// Instantiate the module with the limits and resolved imports of this invocation.
+	instance, err := module.instantiate(limits, r)
+	if err != nil {
+		return -1, err
 	}
+	defer instance.close()
+	defer func() { r.gasUsed = instance.gasUsed() }()
 
 	start := time.Now()
 
 	// Run the WebAssembly chaincode's entry function.
-	result, err := vm.Run(entryID, int64(numberOfArgs))
+	result, found, err := instance.call(funcToInvoke, int64(numberOfArgs))
+	if !found {
+		logger.Errorf("Entry function %s not found; starting from 0.\n", funcToInvoke)
+		r.result = []byte(FnNotPresent)
+		return -1, nil
+	}
 	if err != nil {
-		vm.PrintStackTrace()
-		panic(err)
+		if r.symbols == nil {
+			r.symbols = loadSymbols(r.stub, r.chaincodeName, Chaincodebytes)
+		}
+		return -1, &wasmTrap{err: gasError(err, limits.GasLimit), trace: instance.stackTrace(r.symbols)}
 	}
 	end := time.Now()
 
-	logger.Infof("return value = %d, duration = %v\n", result, end.Sub(start))
+	logger.Infof("return value = %d, gas used = %d (host calls: %d), duration = %v\n", result, instance.gasUsed(), r.hostGasUsed, end.Sub(start))
+
+	return result, nil
+}
+
+// executionError turns a failed wasm run into an error response. Stack traces of traps are
+// always logged but only returned to the client in dev mode.
+func executionError(err error) pb.Response {
+	msg := fmt.Sprintf(ExecutionFailed, err.Error())
+
+	if trap, ok := err.(*wasmTrap); ok {
+		logger.Errorf("%s\n%s", msg, trap.trace)
+		if devMode {
+			return shim.Error(msg + "\n" + trap.trace)
+		}
+		return shim.Error(msg)
+	}
 
-	return result
+	logger.Errorf(msg)
+	return shim.Error(msg)
 }
 
 func txnResult(vmExecResult int64, resultGlobal []byte) pb.Response {
@@ -489,18 +883,18 @@ func txnResult(vmExecResult int64, resultGlobal []byte) pb.Response {
 	}
 }
 
-func decodeReceivedWASMChaincode(encodedChaincode string) ([]byte, error) {
+func decodeReceivedWASMChaincode(encodedChaincode []byte) ([]byte, error) {
 
 	var chaincodeDecoded []byte
 
 	//For hex string passed through cli
-	if strings.HasPrefix(encodedChaincode, "0061736d01000000") {
+	if bytes.HasPrefix(encodedChaincode, []byte("0061736d01000000")) {
 		logger.Infof("Hex encoded wasm chaincode string")
 
-		logger.Debugf("Encoded wasm chaincode: " + encodedChaincode)
-		chaincodeDecoded, _ = hex.DecodeString(encodedChaincode)
+		logger.Debugf("Encoded wasm chaincode: " + string(encodedChaincode))
+		chaincodeDecoded, _ = hex.DecodeString(string(encodedChaincode))
 	} else {
-		decodedBytesTemp := []byte(encodedChaincode)
+		decodedBytesTemp := encodedChaincode
 		kind, _ := filetype.Match(decodedBytesTemp)
 		if kind == filetype.Unknown {
 			logger.Errorf("Unknown file type")
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -62,10 +63,14 @@ var _ = Describe("Tests for wasmcc simple asset transfer", func() {
 				Expect(result.Status).Should(Equal(status200))
 			})
 			Specify("WASM chaincode should be installed successfully", func() {
-				result := stub.MockInvoke("000",
-					[][]byte{[]byte("installedChaincodes")})
-				payload = []byte(result.Payload)
-				Expect(payload).Should(Equal([]byte("balancewasm\nbalancewasm-wasm\nbalancewasm-zip\n")))
+				result := queryPaged(stub, []byte("installedChaincodes"))
+				list := chaincodeList{}
+				Expect(json.Unmarshal(result.Payload, &list)).Should(Succeed())
+				var names []string
+				for _, info := range list.Chaincodes {
+					names = append(names, info.Name)
+				}
+				Expect(names).Should(Equal([]string{"balancewasm", "balancewasm-wasm", "balancewasm-zip"}))
 			})
 		})
 		Context("account1 is created with some balance", func() {
@@ -172,6 +177,55 @@ var _ = Describe("Tests for wasmcc simple asset transfer", func() {
 	})
 })
 
+var _ = Describe("Tests for binary transaction parameters", func() {
+
+	status200 := int32(200)
+	status500 := int32(500)
+
+	const opI32WrapI64 = 0xa7
+	imports := []testImport{
+		{field: "__get_parameter", params: []byte{i32, i32}, results: []byte{i64}},
+		{field: "__get_parameter_size", params: []byte{i32}, results: []byte{i64}},
+		{field: "__return_result", params: []byte{i32, i32}, results: []byte{i64}},
+	}
+	const getParameter, getParameterSize, returnResult = 0, 1, 2
+
+	// echo returns its first parameter, size returns the size of its second parameter
+	paramsModule := buildTestModule(imports, []testFunc{
+		{name: "init", params: []byte{i64}, results: []byte{i64}, body: returnI64(0)},
+		{name: "echo", params: []byte{i64}, results: []byte{i64}, body: concat(
+			[]byte{opI32Const, 16, opI32Const, 0, opI32Const, 16, opCall, getParameter, opI32WrapI64},
+			[]byte{opCall, returnResult, opDrop}, returnI64(0))},
+		{name: "size", params: []byte{i64}, results: []byte{i64}, body: []byte{opI32Const, 1, opCall, getParameterSize}},
+	}, nil)
+
+	stub := shim.NewMockStub("paramsStub", new(WASMChaincode))
+	stub.MockInit("000", nil)
+
+	It("should pass binary parameters unmodified", func() {
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("create"), []byte("paramswasm"), paramsModule})
+		Expect(result.Status).Should(Equal(status200))
+
+		binary := []byte{0x00, 0xff, 0xfe, 0x80, 0x0a, 0xc3, 0x28}
+		result = stub.MockInvoke("000",
+			[][]byte{[]byte("execute"), []byte("paramswasm"), []byte("echo"), binary})
+		Expect(result.Status).Should(Equal(status200))
+		Expect(result.Payload).Should(Equal(binary))
+	})
+	It("should reject parameter positions past the last parameter", func() {
+		result := stub.MockInvoke("000",
+			[][]byte{[]byte("execute"), []byte("paramswasm"), []byte("size"), []byte("a"), []byte("bc")})
+		Expect(result.Status).Should(Equal(status200))
+		Expect(string(result.Payload)).Should(Equal("2"))
+
+		result = stub.MockInvoke("000",
+			[][]byte{[]byte("execute"), []byte("paramswasm"), []byte("size"), []byte("a")})
+		Expect(result.Status).Should(Equal(status500))
+		Expect(result.Message).Should(Equal("-1"))
+	})
+})
+
 func ReadAssetTransferWASMZip() []byte {
 
 	file, err := ioutil.ReadFile("../sample-wasm-chaincode/chaincode_example02/rust/app_main.zip")